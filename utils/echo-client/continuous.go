@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamStats accumulates what came back during a continuous run
+type streamStats struct {
+	mu         sync.Mutex
+	sent       int
+	received   int
+	duplicates int
+	reordered  int
+	highest    uint64
+	seen       map[uint64]bool
+	rtts       []time.Duration
+}
+
+// continuousTransport sends messages and delivers echoed messages to a callback
+type continuousTransport struct {
+	send    func([]byte) error
+	receive func(func(string)) // blocks until the transport is closed
+	close   func()             // lets receive return once pending echoes are drained
+}
+
+// runContinuous sends numbered messages at a fixed rate and validates the echoed sequence
+func runContinuous(config Config) error {
+	idBytes := make([]byte, 4)
+	if _, err := rand.Read(idBytes); err != nil {
+		return fmt.Errorf("failed to generate stream ID: %v", err)
+	}
+	streamID := hex.EncodeToString(idBytes)
+
+	var transport *continuousTransport
+	var err error
+	switch config.Protocol {
+	case "tcp":
+		transport, err = newTCPTransport(config)
+	case "udp":
+		transport, err = newUDPTransport(config)
+	default:
+		return fmt.Errorf("continuous mode is not supported for protocol %s", config.Protocol)
+	}
+	if err != nil {
+		return err
+	}
+
+	stats := &streamStats{seen: make(map[uint64]bool)}
+	interval := time.Duration(float64(time.Second) / config.Rate)
+	total := int(config.Duration / interval)
+
+	// Log progress roughly once per second in verbose mode
+	progressEvery := int(config.Rate)
+	if progressEvery < 1 {
+		progressEvery = 1
+	}
+
+	logf("Stream %s: sending %d messages of %d bytes at %.1f/s for %s", streamID, total, config.Size, config.Rate, config.Duration)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		transport.receive(func(message string) {
+			stats.observe(streamID, message)
+		})
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for seq := 0; seq < total; seq++ {
+		stats.mu.Lock()
+		stats.sent++
+		stats.mu.Unlock()
+
+		if err := transport.send(buildSequenceMessage(streamID, uint64(seq), config.Size)); err != nil {
+			transport.close()
+			return fmt.Errorf("failed to send message %d: %v", seq, err)
+		}
+
+		if config.Verbose && (seq+1)%progressEvery == 0 {
+			stats.mu.Lock()
+			logf("Stream %s: sent %d, received %d", streamID, stats.sent, stats.received)
+			stats.mu.Unlock()
+		}
+
+		<-ticker.C
+	}
+
+	// Wait for stragglers, then tell the server how many messages it should have seen
+	deadline := time.Now().Add(time.Duration(config.Timeout) * time.Second)
+	for time.Now().Before(deadline) {
+		stats.mu.Lock()
+		complete := stats.received >= stats.sent
+		stats.mu.Unlock()
+		if complete {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	transport.send([]byte(fmt.Sprintf("SEQEND %s %d\n", streamID, total)))
+	transport.close()
+	<-done
+
+	lossPercent := stats.report(streamID)
+	if lossPercent > config.MaxLoss {
+		return fmt.Errorf("loss %.2f%% exceeds --max-loss %.2f%%", lossPercent, config.MaxLoss)
+	}
+
+	return nil
+}
+
+// buildSequenceMessage formats a numbered message padded to the requested size
+func buildSequenceMessage(streamID string, seq uint64, size int) []byte {
+	header := fmt.Sprintf("SEQ %s %d %d ", streamID, seq, time.Now().UnixNano())
+
+	padding := size - len(header) - 1
+	if padding < 0 {
+		padding = 0
+	}
+
+	return []byte(header + strings.Repeat("x", padding) + "\n")
+}
+
+func (s *streamStats) observe(streamID, message string) {
+	now := time.Now()
+
+	fields := strings.Fields(message)
+	if len(fields) < 4 || fields[0] != "SEQ" || fields[1] != streamID {
+		return
+	}
+	seq, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return
+	}
+	sentAt, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen[seq] {
+		s.duplicates++
+		return
+	}
+	s.seen[seq] = true
+	s.received++
+	s.rtts = append(s.rtts, now.Sub(time.Unix(0, sentAt)))
+
+	if seq < s.highest {
+		s.reordered++
+	} else {
+		s.highest = seq
+	}
+}
+
+// report prints the final statistics and returns the loss percentage
+func (s *streamStats) report(streamID string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lost := s.sent - s.received
+	lossPercent := 0.0
+	if s.sent > 0 {
+		lossPercent = float64(lost) * 100 / float64(s.sent)
+	}
+
+	sort.Slice(s.rtts, func(i, j int) bool { return s.rtts[i] < s.rtts[j] })
+
+	logf("Stream %s: sent %d, received %d, lost %d (%.2f%%), reordered %d, duplicates %d",
+		streamID, s.sent, s.received, lost, lossPercent, s.reordered, s.duplicates)
+	logf("Stream %s: latency p50 %s, p90 %s, p99 %s, max %s", streamID,
+		percentile(s.rtts, 50), percentile(s.rtts, 90), percentile(s.rtts, 99), percentile(s.rtts, 100))
+
+	return lossPercent
+}
+
+// percentile returns the p-th percentile of an already sorted slice
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := (len(sorted)*p+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index].Round(time.Microsecond)
+}
+
+func newTCPTransport(config Config) (*continuousTransport, error) {
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	conn, err := net.DialTimeout("tcp", addr, time.Duration(config.Timeout)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+
+	if config.TLS {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to configure TLS: %v", err)
+		}
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	if config.Verbose {
+		logf("TCP: Connected to %s", conn.RemoteAddr())
+	}
+
+	return &continuousTransport{
+		send: func(data []byte) error {
+			_, err := conn.Write(data)
+			return err
+		},
+		receive: func(handle func(string)) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				handle(line)
+			}
+		},
+		close: func() {
+			// Half-close so the server sees EOF after echoing everything, instead of a reset
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				conn.SetReadDeadline(time.Now().Add(time.Duration(config.Timeout) * time.Second))
+				cw.CloseWrite()
+				return
+			}
+			conn.Close()
+		},
+	}, nil
+}
+
+func newUDPTransport(config Config) (*continuousTransport, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve UDP address: %v", err)
+	}
+
+	replyConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.ReplyPort})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reply socket: %v", err)
+	}
+
+	sendConn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		replyConn.Close()
+		return nil, fmt.Errorf("failed to connect to server: %v", err)
+	}
+
+	if config.Verbose {
+		logf("UDP: Sending to %s from %s, replies to %s:%d", sendConn.RemoteAddr(), sendConn.LocalAddr(), config.ReplyHost, config.ReplyPort)
+	}
+
+	replyHeader := fmt.Sprintf("%s\n", net.JoinHostPort(config.ReplyHost, strconv.Itoa(config.ReplyPort)))
+
+	return &continuousTransport{
+		send: func(data []byte) error {
+			_, err := sendConn.Write(append([]byte(replyHeader), data...))
+			return err
+		},
+		receive: func(handle func(string)) {
+			buffer := make([]byte, 65536)
+			for {
+				n, _, err := replyConn.ReadFromUDP(buffer)
+				if err != nil {
+					return
+				}
+				handle(string(buffer[:n]))
+			}
+		},
+		close: func() {
+			sendConn.Close()
+			replyConn.Close()
+		},
+	}, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sessionResult is the outcome of one fan-out connection
+type sessionResult struct {
+	connectTime time.Duration
+	rtts        []time.Duration
+	bytes       int
+	err         error
+}
+
+// runFanOut opens config.Connections simultaneous sessions and reports aggregate results
+func runFanOut(config Config) error {
+	var interval time.Duration
+	if config.RampRate > 0 {
+		interval = time.Duration(float64(time.Second) / config.RampRate)
+	}
+
+	logf("Opening %d %s connections to %s:%d (%d messages each)", config.Connections, config.Protocol, config.Host, config.Port, config.Count)
+
+	results := make([]sessionResult, config.Connections)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for i := 0; i < config.Connections; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			var result sessionResult
+			switch config.Protocol {
+			case "tcp":
+				result = runTCPSession(config)
+			case "udp":
+				result = runUDPSession(config)
+			}
+			results[id] = result
+
+			if result.err != nil {
+				logf("Connection %d: %v", id, result.err)
+			} else if config.Verbose {
+				logf("Connection %d: %d messages echoed", id, len(result.rtts))
+			}
+		}(i)
+
+		if interval > 0 && i < config.Connections-1 {
+			time.Sleep(interval)
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	return reportFanOut(results, elapsed)
+}
+
+func runTCPSession(config Config) sessionResult {
+	var result sessionResult
+	timeout := time.Duration(config.Timeout) * time.Second
+	message := []byte(config.Message)
+
+	connectStart := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), timeout)
+	if err != nil {
+		result.err = fmt.Errorf("failed to connect: %v", err)
+		return result
+	}
+	defer conn.Close()
+
+	if config.TLS {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			result.err = fmt.Errorf("failed to configure TLS: %v", err)
+			return result
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			result.err = fmt.Errorf("TLS handshake failed: %v", err)
+			return result
+		}
+		conn = tlsConn
+	}
+	result.connectTime = time.Since(connectStart)
+
+	response := make([]byte, len(message))
+	for i := 0; i < config.Count; i++ {
+		conn.SetDeadline(time.Now().Add(timeout))
+
+		sentAt := time.Now()
+		if _, err := conn.Write(message); err != nil {
+			result.err = fmt.Errorf("failed to write message %d: %v", i, err)
+			return result
+		}
+
+		// TCP may split the echo across reads, so wait for the full message
+		if _, err := io.ReadFull(conn, response); err != nil {
+			result.err = fmt.Errorf("failed to read response %d: %v", i, err)
+			return result
+		}
+		if string(response) != config.Message {
+			result.err = fmt.Errorf("echo mismatch on message %d: got %q", i, response)
+			return result
+		}
+
+		result.rtts = append(result.rtts, time.Since(sentAt))
+		result.bytes += len(message)
+	}
+
+	return result
+}
+
+func runUDPSession(config Config) sessionResult {
+	var result sessionResult
+	timeout := time.Duration(config.Timeout) * time.Second
+
+	serverAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		result.err = fmt.Errorf("failed to resolve UDP address: %v", err)
+		return result
+	}
+
+	connectStart := time.Now()
+
+	// Each session gets its own reply port so responses can't cross between sessions
+	replyConn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		result.err = fmt.Errorf("failed to create reply socket: %v", err)
+		return result
+	}
+	defer replyConn.Close()
+
+	sendConn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		result.err = fmt.Errorf("failed to connect to server: %v", err)
+		return result
+	}
+	defer sendConn.Close()
+
+	result.connectTime = time.Since(connectStart)
+
+	replyPort := replyConn.LocalAddr().(*net.UDPAddr).Port
+	payload := []byte(fmt.Sprintf("%s\n%s", net.JoinHostPort(config.ReplyHost, strconv.Itoa(replyPort)), config.Message))
+	buffer := make([]byte, 4096)
+
+	for i := 0; i < config.Count; i++ {
+		sendConn.SetWriteDeadline(time.Now().Add(timeout))
+		replyConn.SetReadDeadline(time.Now().Add(timeout))
+
+		sentAt := time.Now()
+		if _, err := sendConn.Write(payload); err != nil {
+			result.err = fmt.Errorf("failed to write message %d: %v", i, err)
+			return result
+		}
+
+		n, _, err := replyConn.ReadFromUDP(buffer)
+		if err != nil {
+			result.err = fmt.Errorf("failed to read response %d: %v", i, err)
+			return result
+		}
+		if string(buffer[:n]) != config.Message {
+			result.err = fmt.Errorf("echo mismatch on message %d: got %q", i, buffer[:n])
+			return result
+		}
+
+		result.rtts = append(result.rtts, time.Since(sentAt))
+		result.bytes += len(config.Message)
+	}
+
+	return result
+}
+
+// reportFanOut prints aggregate results and returns an error if any connection failed
+func reportFanOut(results []sessionResult, elapsed time.Duration) error {
+	var rtts, connectTimes []time.Duration
+	var messages, bytes, failed int
+
+	for _, result := range results {
+		rtts = append(rtts, result.rtts...)
+		messages += len(result.rtts)
+		bytes += result.bytes
+
+		if result.err != nil {
+			failed++
+			continue
+		}
+		connectTimes = append(connectTimes, result.connectTime)
+	}
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	sort.Slice(connectTimes, func(i, j int) bool { return connectTimes[i] < connectTimes[j] })
+
+	seconds := elapsed.Seconds()
+	logf("Connections: %d ok, %d failed in %s", len(results)-failed, failed, elapsed.Round(time.Millisecond))
+	logf("Throughput: %d messages (%.1f/s), %d bytes echoed (%.1f KB/s)", messages, float64(messages)/seconds, bytes, float64(bytes)/1024/seconds)
+	logf("Connect time: p50 %s, p99 %s, max %s", percentile(connectTimes, 50), percentile(connectTimes, 99), percentile(connectTimes, 100))
+	logf("Round trip: p50 %s, p99 %s, max %s", percentile(rtts, 50), percentile(rtts, 99), percentile(rtts, 100))
+
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d connections failed", failed, len(results))
+}
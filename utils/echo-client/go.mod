@@ -1,3 +1,17 @@
 module echo-client
 
-go 1.21
\ No newline at end of file
+go 1.24
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/quic-go/quic-go v0.59.1
+	voip v0.0.0
+)
+
+require (
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+)
+
+replace voip => ../voip
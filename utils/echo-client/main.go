@@ -1,15 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"os/exec"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
+
+	"voip/netutil"
 )
 
 type Config struct {
@@ -21,6 +24,32 @@ type Config struct {
 	Verbose   bool
 	ReplyHost string
 	ReplyPort int
+
+	TLS           bool
+	TLSCA         string
+	TLSCert       string
+	TLSKey        string
+	TLSServerName string
+	TLSInsecure   bool
+
+	WSPath   string
+	WSBinary bool
+
+	QUIC0RTT bool
+
+	Continuous bool
+	Rate       float64
+	Size       int
+	Duration   time.Duration
+	MaxLoss    float64
+
+	Connections int
+	Count       int
+	RampRate    float64
+
+	ScanPorts   string
+	ScanRate    float64
+	ScanRetries int
 }
 
 func main() {
@@ -28,20 +57,89 @@ func main() {
 
 	flag.StringVar(&config.Host, "host", "localhost", "Server host/IP")
 	flag.IntVar(&config.Port, "port", 1505, "Server port")
-	flag.StringVar(&config.Protocol, "protocol", "tcp", "Protocol (tcp or udp)")
+	flag.StringVar(&config.Protocol, "protocol", "tcp", "Protocol (tcp, udp, ws or quic)")
 	flag.StringVar(&config.Message, "message", "Hello, Echo Server!", "Message to send")
 	flag.IntVar(&config.Timeout, "timeout", 5, "Timeout in seconds")
 	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
 	flag.StringVar(&config.ReplyHost, "reply-host", "", "Custom reply host for UDP (auto-detected if not specified)")
 	flag.IntVar(&config.ReplyPort, "reply-port", 0, "Custom reply port for UDP (random if not specified)")
+	flag.BoolVar(&config.TLS, "tls", false, "Use TLS for TCP and WebSocket connections (QUIC always uses TLS)")
+	flag.StringVar(&config.TLSCA, "tls-ca", "", "CA file for verifying the server certificate (system roots if not specified)")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "Client certificate file for mutual TLS")
+	flag.StringVar(&config.TLSKey, "tls-key", "", "Client private key file for mutual TLS")
+	flag.StringVar(&config.TLSServerName, "tls-server-name", "", "Server name to verify (defaults to --host)")
+	flag.BoolVar(&config.TLSInsecure, "tls-insecure", false, "Skip server certificate verification")
+	flag.StringVar(&config.WSPath, "ws-path", "/", "HTTP path of the WebSocket echo endpoint")
+	flag.BoolVar(&config.WSBinary, "ws-binary", false, "Send WebSocket messages as binary frames instead of text")
+	flag.BoolVar(&config.QUIC0RTT, "quic-0rtt", false, "Reconnect with 0-RTT after the first QUIC echo and verify it is accepted")
+	flag.BoolVar(&config.Continuous, "continuous", false, "Stream numbered messages and validate the echoed sequence (tcp and udp)")
+	flag.Float64Var(&config.Rate, "rate", 50, "Messages per second in continuous mode")
+	flag.IntVar(&config.Size, "size", 160, "Message size in bytes in continuous mode")
+	flag.DurationVar(&config.Duration, "duration", 10*time.Second, "How long to stream in continuous mode")
+	flag.Float64Var(&config.MaxLoss, "max-loss", 0, "Maximum acceptable loss percentage in continuous mode")
+	flag.IntVar(&config.Connections, "connections", 1, "Number of simultaneous connections (tcp and udp)")
+	flag.IntVar(&config.Count, "count", 1, "Messages to echo per connection with --connections")
+	flag.Float64Var(&config.RampRate, "ramp-rate", 50, "New connections per second with --connections (0 = all at once)")
+	flag.StringVar(&config.ScanPorts, "scan-ports", "", "Probe every UDP port in FIRST-LAST on --host and report which ones echo (e.g. 10000-20000)")
+	flag.Float64Var(&config.ScanRate, "scan-rate", 1000, "Probes per second in scan mode")
+	flag.IntVar(&config.ScanRetries, "scan-retries", 2, "Extra rounds for unanswered ports in scan mode")
 	flag.Parse()
 
 	config.Protocol = strings.ToLower(config.Protocol)
 
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		log.Fatalf("Both --tls-cert and --tls-key must be specified")
+	}
+	if config.TLS && config.Protocol == "udp" {
+		log.Fatalf("--tls is not supported with --protocol udp")
+	}
+
+	if config.Continuous {
+		if config.Protocol != "tcp" && config.Protocol != "udp" {
+			log.Fatalf("--continuous is only supported with --protocol tcp or udp")
+		}
+		if config.Rate <= 0 {
+			log.Fatalf("Invalid rate: %v", config.Rate)
+		}
+		if config.Size < 1 || config.Size > 4000 {
+			log.Fatalf("Invalid size: %d (expected 1-4000 bytes)", config.Size)
+		}
+	}
+
+	if config.ScanPorts != "" {
+		if config.Protocol != "udp" {
+			log.Fatalf("--scan-ports requires --protocol udp")
+		}
+		if config.ScanRate <= 0 || config.ScanRetries < 0 {
+			log.Fatalf("Invalid scan rate or retries: %v, %d", config.ScanRate, config.ScanRetries)
+		}
+
+		if err := runPortScan(config); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println("✓ All ports reachable!")
+		os.Exit(0)
+	}
+
+	if config.Connections > 1 {
+		if config.Protocol != "tcp" && config.Protocol != "udp" {
+			log.Fatalf("--connections is only supported with --protocol tcp or udp")
+		}
+		if config.Continuous {
+			log.Fatalf("--connections cannot be combined with --continuous")
+		}
+		if config.ReplyPort != 0 {
+			log.Fatalf("--reply-port cannot be used with --connections (each connection picks its own)")
+		}
+		if config.RampRate < 0 || config.Count < 1 {
+			log.Fatalf("Invalid ramp rate or count: %v, %d", config.RampRate, config.Count)
+		}
+	}
+
 	// For UDP, auto-detect reply host and port if not specified
 	if config.Protocol == "udp" {
 		if config.ReplyHost == "" {
-			if host, err := getDefaultGatewayInterface(); err == nil {
+			if host, err := netutil.DefaultRouteIP(); err == nil {
 				config.ReplyHost = host
 			} else {
 				config.ReplyHost = "127.0.0.1"
@@ -49,6 +147,16 @@ func main() {
 					logf("Failed to auto-detect reply host: %v, using %s", err, config.ReplyHost)
 				}
 			}
+		} else {
+			// The server only accepts a literal IP in the reply header
+			ip, err := resolveReplyHost(config.ReplyHost)
+			if err != nil {
+				log.Fatalf("Invalid --reply-host: %v", err)
+			}
+			if ip != config.ReplyHost && config.Verbose {
+				logf("Resolved reply host %s to %s", config.ReplyHost, ip)
+			}
+			config.ReplyHost = ip
 		}
 
 		if config.ReplyPort == 0 {
@@ -67,11 +175,30 @@ func main() {
 		logf("Connecting to %s://%s:%d", config.Protocol, config.Host, config.Port)
 		logf("Message: %q", config.Message)
 		logf("Timeout: %d seconds", config.Timeout)
+		if config.TLS {
+			logf("TLS: enabled (client certificate: %t, verify: %t)", config.TLSCert != "", !config.TLSInsecure)
+		}
 		if config.Protocol == "udp" && config.ReplyHost != "" {
 			logf("Custom reply address: %s:%d", config.ReplyHost, config.ReplyPort)
 		}
 	}
 
+	if config.Connections > 1 {
+		if err := runFanOut(config); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println("✓ All connections echoed successfully!")
+		os.Exit(0)
+	}
+
+	if config.Continuous {
+		if err := runContinuous(config); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println("✓ Continuous echo successful!")
+		os.Exit(0)
+	}
+
 	var response string
 	var err error
 
@@ -80,6 +207,10 @@ func main() {
 		response, err = sendTCP(config)
 	case "udp":
 		response, err = sendUDP(config)
+	case "ws":
+		response, err = sendWS(config)
+	case "quic":
+		response, err = sendQUIC(config)
 	default:
 		log.Fatalf("Unsupported protocol: %s", config.Protocol)
 	}
@@ -101,7 +232,7 @@ func main() {
 }
 
 func sendTCP(config Config) (string, error) {
-	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
 
 	if config.Verbose {
 		logf("TCP: Connecting to %s", addr)
@@ -117,6 +248,28 @@ func sendTCP(config Config) (string, error) {
 		logf("TCP: Connected to %s", conn.RemoteAddr())
 	}
 
+	if config.TLS {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			return "", fmt.Errorf("failed to configure TLS: %v", err)
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(time.Duration(config.Timeout) * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			return "", fmt.Errorf("TLS handshake failed: %v", err)
+		}
+		conn = tlsConn
+
+		if config.Verbose {
+			state := tlsConn.ConnectionState()
+			logf("TCP: TLS established (%s, %s)", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+			if len(state.PeerCertificates) > 0 {
+				logf("TCP: Server certificate: %s (issuer: %s)", state.PeerCertificates[0].Subject, state.PeerCertificates[0].Issuer)
+			}
+		}
+	}
+
 	// Set read/write timeouts
 	timeout := time.Duration(config.Timeout) * time.Second
 	conn.SetWriteDeadline(time.Now().Add(timeout))
@@ -148,7 +301,7 @@ func sendTCP(config Config) (string, error) {
 }
 
 func sendUDP(config Config) (string, error) {
-	serverAddr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	serverAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
 
 	if config.Verbose {
 		logf("UDP: Connecting to %s", serverAddr)
@@ -193,7 +346,7 @@ func sendUDP(config Config) (string, error) {
 	replyConn.SetReadDeadline(time.Now().Add(timeout))
 
 	// Always use new-style format for UDP
-	messageToSend := fmt.Sprintf("%s:%d\n%s", config.ReplyHost, config.ReplyPort, config.Message)
+	messageToSend := fmt.Sprintf("%s\n%s", net.JoinHostPort(config.ReplyHost, strconv.Itoa(config.ReplyPort)), config.Message)
 
 	// Send message
 	if config.Verbose {
@@ -219,53 +372,65 @@ func sendUDP(config Config) (string, error) {
 	return response, nil
 }
 
+// loadTLSConfig builds the client TLS configuration from the certificate flags
+func loadTLSConfig(config Config) (*tls.Config, error) {
+	serverName := config.TLSServerName
+	if serverName == "" {
+		serverName = config.Host
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: config.TLSInsecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if config.TLSCA != "" {
+		caPEM, err := os.ReadFile(config.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
 // logf prints a timestamped log message
 func logf(format string, args ...interface{}) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
 	fmt.Printf("[%s] %s\n", timestamp, fmt.Sprintf(format, args...))
 }
 
-// getDefaultGatewayInterface returns the IP address of the default gateway interface
-func getDefaultGatewayInterface() (string, error) {
-	// Run route command to get default gateway interface
-	cmd := exec.Command("route", "-n", "get", "default")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to run route command: %v", err)
-	}
-
-	// Parse the output to find the interface
-	lines := strings.Split(string(output), "\n")
-	var interfaceName string
-	for _, line := range lines {
-		if strings.Contains(line, "interface:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				interfaceName = parts[1]
-				break
-			}
-		}
-	}
-
-	if interfaceName == "" {
-		return "", fmt.Errorf("could not find default gateway interface")
+// resolveReplyHost turns a --reply-host name into an IP address, preferring IPv4
+func resolveReplyHost(host string) (string, error) {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return ip.String(), nil
 	}
 
-	// Get IP address of the interface
-	cmd = exec.Command("ifconfig", interfaceName)
-	output, err = cmd.Output()
+	ips, err := net.LookupIP(host)
 	if err != nil {
-		return "", fmt.Errorf("failed to run ifconfig: %v", err)
+		return "", fmt.Errorf("failed to resolve %s: %v", host, err)
 	}
-
-	// Parse ifconfig output to find IPv4 address
-	re := regexp.MustCompile(`inet\s+(\d+\.\d+\.\d+\.\d+)`)
-	matches := re.FindStringSubmatch(string(output))
-	if len(matches) < 2 {
-		return "", fmt.Errorf("could not find IPv4 address for interface %s", interfaceName)
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
 	}
-
-	return matches[1], nil
+	return ips[0].String(), nil
 }
 
 // getRandomPort returns a random available port
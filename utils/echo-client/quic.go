@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN is the application protocol negotiated by the QUIC echo endpoint
+const quicALPN = "echo-quic"
+
+func sendQUIC(config Config) (string, error) {
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+
+	tlsConfig, err := loadTLSConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to configure TLS: %v", err)
+	}
+	tlsConfig.MinVersion = tls.VersionTLS13
+	tlsConfig.NextProtos = []string{quicALPN}
+	// Session tickets from the first connection are what make 0-RTT possible
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	quicConfig := &quic.Config{HandshakeIdleTimeout: timeout}
+
+	if config.Verbose {
+		logf("QUIC: Connecting to %s", addr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, addr, tlsConfig, quicConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %v", err)
+	}
+
+	if config.Verbose {
+		state := conn.ConnectionState()
+		logf("QUIC: Connected to %s (QUIC v%d, %s)", conn.RemoteAddr(), state.Version, tls.CipherSuiteName(state.TLS.CipherSuite))
+	}
+
+	response, err := echoQUICStream(ctx, conn, config)
+	conn.CloseWithError(0, "")
+	if err != nil {
+		return "", err
+	}
+
+	if !config.QUIC0RTT {
+		return response, nil
+	}
+
+	if config.Verbose {
+		logf("QUIC: Reconnecting to %s with 0-RTT", addr)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	earlyConn, err := quic.DialAddrEarly(ctx, addr, tlsConfig, quicConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to reconnect: %v", err)
+	}
+	defer earlyConn.CloseWithError(0, "")
+
+	earlyResponse, err := echoQUICStream(ctx, earlyConn, config)
+	if err != nil {
+		return "", fmt.Errorf("0-RTT echo failed: %v", err)
+	}
+
+	select {
+	case <-earlyConn.HandshakeComplete():
+	case <-ctx.Done():
+		return "", fmt.Errorf("0-RTT handshake did not complete: %v", ctx.Err())
+	}
+
+	if !earlyConn.ConnectionState().Used0RTT {
+		return "", fmt.Errorf("server did not accept 0-RTT on reconnect")
+	}
+	if earlyResponse != response {
+		return "", fmt.Errorf("0-RTT echo mismatch: got %q", earlyResponse)
+	}
+
+	logf("QUIC: 0-RTT reconnect accepted")
+
+	return earlyResponse, nil
+}
+
+// echoQUICStream sends the message on a new stream and reads the echo until the server closes it
+func echoQUICStream(ctx context.Context, conn *quic.Conn, config Config) (string, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to open stream: %v", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if config.Verbose {
+		logf("QUIC: Sending on stream %d: %q", stream.StreamID(), config.Message)
+	}
+
+	if _, err := stream.Write([]byte(config.Message)); err != nil {
+		return "", fmt.Errorf("failed to write: %v", err)
+	}
+
+	// Closing the send side lets the server see EOF and finish the stream
+	if err := stream.Close(); err != nil {
+		return "", fmt.Errorf("failed to close stream: %v", err)
+	}
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	response := string(data)
+	if config.Verbose {
+		logf("QUIC: Received on stream %d: %q", stream.StreamID(), response)
+	}
+
+	return response, nil
+}
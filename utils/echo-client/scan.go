@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runPortScan probes every UDP port in config.ScanPorts and reports which ones echoed back
+func runPortScan(config Config) error {
+	first, last, err := parsePortRange(config.ScanPorts)
+	if err != nil {
+		return fmt.Errorf("invalid --scan-ports: %v", err)
+	}
+
+	host, err := net.ResolveIPAddr("ip", config.Host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", config.Host, err)
+	}
+
+	// A single socket is used for all probes so replies come back through the
+	// same NAT binding, the way symmetric RTP would
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return fmt.Errorf("failed to create probe socket: %v", err)
+	}
+	defer conn.Close()
+
+	total := last - first + 1
+	logf("Scanning UDP ports %d-%d on %s from %s (%d ports)", first, last, host, conn.LocalAddr(), total)
+
+	var mu sync.Mutex
+	reachable := make(map[int]bool)
+
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			// The payload names the probed port, which also covers servers that reply from another port
+			port, err := strconv.Atoi(strings.TrimPrefix(string(buffer[:n]), "SCAN "))
+			if err != nil || port < first || port > last {
+				continue
+			}
+
+			mu.Lock()
+			if !reachable[port] && config.Verbose {
+				logf("UDP: Port %d reachable (reply from %s)", port, from)
+			}
+			reachable[port] = true
+			mu.Unlock()
+		}
+	}()
+
+	interval := time.Duration(float64(time.Second) / config.ScanRate)
+	for attempt := 0; attempt <= config.ScanRetries; attempt++ {
+		var pending []int
+		mu.Lock()
+		for port := first; port <= last; port++ {
+			if !reachable[port] {
+				pending = append(pending, port)
+			}
+		}
+		mu.Unlock()
+
+		if len(pending) == 0 {
+			break
+		}
+		if attempt > 0 {
+			logf("Retrying %d unanswered ports (attempt %d of %d)", len(pending), attempt, config.ScanRetries)
+		}
+
+		for _, port := range pending {
+			target := &net.UDPAddr{IP: host.IP, Port: port, Zone: host.Zone}
+			if _, err := conn.WriteToUDP([]byte(fmt.Sprintf("SCAN %d", port)), target); err != nil {
+				return fmt.Errorf("failed to probe port %d: %v", port, err)
+			}
+			time.Sleep(interval)
+		}
+
+		// Give the last probes of this round time to come back
+		time.Sleep(time.Duration(config.Timeout) * time.Second)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var open, closed []int
+	for port := first; port <= last; port++ {
+		if reachable[port] {
+			open = append(open, port)
+		} else {
+			closed = append(closed, port)
+		}
+	}
+
+	logf("Reachable: %d of %d ports", len(open), total)
+	if len(open) > 0 {
+		logf("Reachable ports: %s", formatPortRanges(open))
+	}
+	if len(closed) > 0 {
+		logf("Unreachable ports: %s", formatPortRanges(closed))
+		return fmt.Errorf("%d of %d ports did not respond", len(closed), total)
+	}
+
+	return nil
+}
+
+// parsePortRange parses a "first-last" port range, or a single port
+func parsePortRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+
+	first, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid first port %q", parts[0])
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid last port %q", parts[1])
+	}
+
+	if first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("range %d-%d is out of bounds", first, last)
+	}
+
+	return first, last, nil
+}
+
+// formatPortRanges collapses sorted ports into a compact "10000-10099, 10500" form
+func formatPortRanges(ports []int) string {
+	sort.Ints(ports)
+
+	var ranges []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(ports[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", ports[i], ports[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(ranges, ", ")
+}
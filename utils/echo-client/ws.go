@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func sendWS(config Config) (string, error) {
+	scheme := "ws"
+	if config.TLS {
+		scheme = "wss"
+	}
+	wsURL := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		Path:   config.WSPath,
+	}
+
+	if config.Verbose {
+		logf("WS: Connecting to %s", wsURL.String())
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	dialer := websocket.Dialer{HandshakeTimeout: timeout}
+	if config.TLS {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			return "", fmt.Errorf("failed to configure TLS: %v", err)
+		}
+		dialer.TLSClientConfig = tlsConfig
+	}
+
+	conn, resp, err := dialer.Dial(wsURL.String(), nil)
+	if err != nil {
+		if resp != nil {
+			return "", fmt.Errorf("failed to connect: %v (HTTP %s)", err, resp.Status)
+		}
+		return "", fmt.Errorf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if config.Verbose {
+		logf("WS: Connected to %s", conn.RemoteAddr())
+	}
+
+	messageType := websocket.TextMessage
+	if config.WSBinary {
+		messageType = websocket.BinaryMessage
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	if config.Verbose {
+		logf("WS: Sending %s frame: %q", frameTypeName(messageType), config.Message)
+	}
+
+	if err := conn.WriteMessage(messageType, []byte(config.Message)); err != nil {
+		return "", fmt.Errorf("failed to write: %v", err)
+	}
+
+	responseType, response, err := conn.ReadMessage()
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if config.Verbose {
+		logf("WS: Received %s frame: %q", frameTypeName(responseType), response)
+	}
+
+	if responseType != messageType {
+		return "", fmt.Errorf("expected %s frame in response, got %s", frameTypeName(messageType), frameTypeName(responseType))
+	}
+
+	// Close cleanly so the server logs a normal closure
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+	return string(response), nil
+}
+
+// frameTypeName returns a human-readable name for a WebSocket data frame type
+func frameTypeName(messageType int) string {
+	switch messageType {
+	case websocket.TextMessage:
+		return "text"
+	case websocket.BinaryMessage:
+		return "binary"
+	default:
+		return fmt.Sprintf("type %d", messageType)
+	}
+}
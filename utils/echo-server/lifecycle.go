@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// connHandle is a tracked client connection that can be asked to wind down
+type connHandle struct {
+	tracker *connTracker
+	nudge   func(reason string) // makes a blocked read return so the handler can finish up
+	close   func()              // forcibly closes the connection
+	timer   *time.Timer
+
+	mu     sync.Mutex
+	reason string
+}
+
+// expired reports why the connection should stop, or "" if it may continue
+func (h *connHandle) expired() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.reason
+}
+
+// expire marks the connection as finished and wakes up its handler
+func (h *connHandle) expire(reason string) {
+	h.mu.Lock()
+	if h.reason != "" {
+		h.mu.Unlock()
+		return
+	}
+	h.reason = reason
+	h.mu.Unlock()
+
+	h.nudge(reason)
+}
+
+// done removes the connection from the tracker once its handler has returned
+func (h *connHandle) done() {
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+
+	t := h.tracker
+	t.mu.Lock()
+	delete(t.conns, h)
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// connTracker keeps track of listeners and live connections so shutdown can drain them
+type connTracker struct {
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	listeners []io.Closer
+	conns     map[*connHandle]struct{}
+	draining  bool
+	maxAge    time.Duration
+}
+
+var connections = &connTracker{conns: make(map[*connHandle]struct{})}
+
+// addListener registers a listener to be closed when shutdown starts
+func (t *connTracker) addListener(listener io.Closer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		listener.Close()
+		return
+	}
+	t.listeners = append(t.listeners, listener)
+}
+
+// track registers a connection; it returns false if the server is already shutting down
+func (t *connTracker) track(nudge func(reason string), close func()) (*connHandle, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return nil, false
+	}
+
+	h := &connHandle{tracker: t, nudge: nudge, close: close}
+	t.conns[h] = struct{}{}
+	t.wg.Add(1)
+
+	if t.maxAge > 0 {
+		h.timer = time.AfterFunc(t.maxAge, func() { h.expire("max connection age reached") })
+	}
+
+	return h, true
+}
+
+// shutdown closes all listeners and asks every connection to finish, returning how many were active
+func (t *connTracker) shutdown() int {
+	t.mu.Lock()
+	t.draining = true
+	listeners := t.listeners
+	t.listeners = nil
+	handles := make([]*connHandle, 0, len(t.conns))
+	for h := range t.conns {
+		handles = append(handles, h)
+	}
+	t.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	for _, h := range handles {
+		h.expire("server shutting down")
+	}
+
+	return len(handles)
+}
+
+// wait blocks until all connections are done or the timeout elapses
+func (t *connTracker) wait(timeout time.Duration) bool {
+	drained := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// closeAll forcibly closes whatever is still open, returning how many connections were closed
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	handles := make([]*connHandle, 0, len(t.conns))
+	for h := range t.conns {
+		handles = append(handles, h)
+	}
+	t.mu.Unlock()
+
+	for _, h := range handles {
+		h.close()
+	}
+
+	return len(handles)
+}
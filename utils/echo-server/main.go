@@ -1,23 +1,47 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"os"
 	"os/signal"
-	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
 type Config struct {
-	Port      int
-	Protocols []string
-	Verbose   bool
+	Port        int
+	Protocols   []string
+	Verbose     bool
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+	WSPort      int
+	WSPath      string
+	WSDelay     time.Duration
+	QUICPort    int
+
+	UDPPortRange string
+
+	DrainTimeout time.Duration
+	MaxConnAge   time.Duration
+
+	MetricsPort int
+	AccessLog   bool
+
+	RTP           bool
+	RTPDelay      time.Duration
+	RTPSSRC       uint
+	RTPRewriteSeq bool
 }
 
 func main() {
@@ -25,22 +49,58 @@ func main() {
 	var protocolsFlag string
 
 	flag.IntVar(&config.Port, "port", 1505, "Port to listen on")
-	flag.StringVar(&protocolsFlag, "protocols", "tcp,udp", "Protocols to support (tcp,udp or both)")
+	flag.StringVar(&protocolsFlag, "protocols", "tcp,udp", "Protocols to support (comma-separated: tcp, udp, ws, quic)")
 	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "TLS certificate file (enables TLS for TCP and WebSocket, used by QUIC)")
+	flag.StringVar(&config.TLSKey, "tls-key", "", "TLS private key file")
+	flag.StringVar(&config.TLSClientCA, "tls-client-ca", "", "CA file for verifying client certificates (enables mutual TLS)")
+	flag.IntVar(&config.WSPort, "ws-port", 1506, "Port for the WebSocket echo endpoint")
+	flag.StringVar(&config.WSPath, "ws-path", "/", "HTTP path of the WebSocket echo endpoint")
+	flag.DurationVar(&config.WSDelay, "ws-delay", 0, "Delay before echoing each WebSocket message")
+	flag.StringVar(&config.UDPPortRange, "udp-port-range", "", "Listen for UDP on every port in FIRST-LAST instead of --port (e.g. 10000-20000)")
+	flag.IntVar(&config.QUICPort, "quic-port", 1507, "UDP port for the QUIC echo endpoint")
+	flag.DurationVar(&config.DrainTimeout, "drain-timeout", 10*time.Second, "How long to wait for connections to finish on shutdown")
+	flag.DurationVar(&config.MaxConnAge, "max-conn-age", 0, "Close TCP, WebSocket and QUIC connections older than this (0 = unlimited)")
+	flag.IntVar(&config.MetricsPort, "metrics-port", 0, "Port for the Prometheus metrics endpoint (0 = disabled)")
+	flag.BoolVar(&config.AccessLog, "access-log", false, "Log one line per finished TCP, WebSocket and QUIC connection")
+	flag.BoolVar(&config.RTP, "rtp", false, "Treat UDP packets as RTP: validate headers and echo back to the source")
+	flag.DurationVar(&config.RTPDelay, "rtp-delay", 0, "Delay before echoing each RTP packet")
+	flag.UintVar(&config.RTPSSRC, "rtp-ssrc", 0, "Rewrite the SSRC of echoed RTP packets (0 = keep)")
+	flag.BoolVar(&config.RTPRewriteSeq, "rtp-rewrite-seq", false, "Renumber echoed RTP packets from a random starting sequence number")
 	flag.Parse()
 
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		log.Fatalf("Both --tls-cert and --tls-key must be specified")
+	}
+	if config.TLSClientCA != "" && config.TLSCert == "" {
+		log.Fatalf("--tls-client-ca requires --tls-cert and --tls-key")
+	}
+	if config.RTPSSRC > 0xffffffff {
+		log.Fatalf("--rtp-ssrc must fit in 32 bits")
+	}
+
 	config.Protocols = strings.Split(protocolsFlag, ",")
 	for i, p := range config.Protocols {
 		config.Protocols[i] = strings.TrimSpace(strings.ToLower(p))
 	}
 
+	connections.maxAge = config.MaxConnAge
+	accessLog = config.AccessLog
+
 	logf("Starting Echo Server on port %d", config.Port)
 	logf("Protocols: %v", config.Protocols)
+	if config.RTP {
+		logf("UDP: RTP mode, echoing valid RTP packets back to their source (delay %s)", config.RTPDelay)
+	}
 
 	// Setup signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	if config.MetricsPort != 0 {
+		go startMetricsServer(config)
+	}
+
 	// Start servers for each protocol
 	for _, protocol := range config.Protocols {
 		switch protocol {
@@ -48,6 +108,10 @@ func main() {
 			go startTCPServer(config)
 		case "udp":
 			go startUDPServer(config)
+		case "ws":
+			go startWSServer(config)
+		case "quic":
+			go startQUICServer(config)
 		default:
 			log.Fatalf("Unsupported protocol: %s", protocol)
 		}
@@ -55,7 +119,22 @@ func main() {
 
 	// Wait for shutdown signal
 	<-sigChan
-	logf("Shutdown signal received, stopping servers...")
+
+	// A second signal skips draining
+	go func() {
+		<-sigChan
+		logf("Second shutdown signal received, exiting immediately")
+		os.Exit(1)
+	}()
+
+	active := connections.shutdown()
+	logf("Shutdown signal received, stopped listeners, draining %d connections (timeout %s)...", active, config.DrainTimeout)
+
+	if !connections.wait(config.DrainTimeout) {
+		closed := connections.closeAll()
+		logf("Drain timeout reached, closed %d remaining connections", closed)
+	}
+	logf("Shutdown complete")
 }
 
 // logf prints a timestamped log message
@@ -65,43 +144,95 @@ func logf(format string, args ...interface{}) {
 }
 
 // parseUDPMessage parses the UDP message and extracts custom reply address if present
-// Format: "<IP>:<PORT>\n<MESSAGE>"
+// Format: "<IP>:<PORT>\n<MESSAGE>", with IPv6 addresses in brackets
 // Returns the reply address and the actual message to echo
 func parseUDPMessage(message string, defaultAddr *net.UDPAddr) (*net.UDPAddr, string) {
 	// Check if message contains a newline (potential custom address format)
 	if idx := strings.Index(message, "\n"); idx != -1 {
 		firstLine := message[:idx]
 		actualMessage := message[idx+1:]
-		
-		// Basic regex to match IP:PORT pattern
-		// Supports IPv4 and IPv6 addresses
-		ipPortRegex := regexp.MustCompile(`^(.+):(\d+)$`)
-		if matches := ipPortRegex.FindStringSubmatch(firstLine); matches != nil {
-			customAddr := firstLine
-			if udpAddr, err := net.ResolveUDPAddr("udp", customAddr); err == nil {
-				return udpAddr, actualMessage
-			}
+
+		// Only IP literals are accepted: resolving hostnames taken from packet
+		// payloads would put a DNS lookup in the echo loop for every datagram
+		if addrPort, err := netip.ParseAddrPort(firstLine); err == nil && isValidReplyAddr(addrPort) {
+			return net.UDPAddrFromAddrPort(addrPort), actualMessage
 		}
 	}
-	
+
 	// Fallback to default behavior: use source address and treat whole payload as message
 	return defaultAddr, message
 }
 
+// isValidReplyAddr rejects reply addresses that can't be sent to, like port 0 or 0.0.0.0.
+// Zones and IPv4 mapping would otherwise hide an unspecified address ([::%eth0], [::ffff:0.0.0.0])
+func isValidReplyAddr(addrPort netip.AddrPort) bool {
+	return addrPort.Port() != 0 && !addrPort.Addr().Unmap().WithZone("").IsUnspecified()
+}
+
+// loadTLSConfig builds the server TLS configuration from the certificate flags
+func loadTLSConfig(config Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.TLSClientCA != "" {
+		caPEM, err := os.ReadFile(config.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSClientCA)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
 func startTCPServer(config Config) {
 	addr := fmt.Sprintf("0.0.0.0:%d", config.Port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("Failed to start TCP server: %v", err)
 	}
+
+	if config.TLSCert != "" {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 	defer listener.Close()
+	connections.addListener(listener)
 
-	logf("TCP Echo Server listening on %s", addr)
+	switch {
+	case config.TLSClientCA != "":
+		logf("TCP Echo Server listening on %s (mutual TLS)", addr)
+	case config.TLSCert != "":
+		logf("TCP Echo Server listening on %s (TLS)", addr)
+	default:
+		logf("TCP Echo Server listening on %s", addr)
+	}
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Printf("Failed to accept TCP connection: %v", err)
+			metrics.failed("tcp", "accept")
 			continue
 		}
 
@@ -112,14 +243,51 @@ func startTCPServer(config Config) {
 func handleTCPConnection(conn net.Conn, verbose bool) {
 	defer conn.Close()
 
+	// Expiring the connection moves the read deadline to now, so the current
+	// echo completes and the next read returns instead of blocking
+	handle, ok := connections.track(
+		func(string) { conn.SetReadDeadline(time.Now()) },
+		func() { conn.Close() },
+	)
+	if !ok {
+		return
+	}
+	defer handle.done()
+
 	clientAddr := conn.RemoteAddr().String()
 	if verbose {
 		logf("TCP: New connection from %s", clientAddr)
 	}
 
+	stats := metrics.connectionOpened("tcp", clientAddr)
+	defer stats.close()
+
 	// Set read timeout
 	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 
+	// Complete the TLS handshake up front so failures are reported as such
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			if reason := handle.expired(); reason != "" {
+				stats.reason = reason
+				return
+			}
+			log.Printf("TCP: TLS handshake with %s failed: %v", clientAddr, err)
+			metrics.failed("tcp", "tls_handshake")
+			stats.reason = "TLS handshake failed"
+			return
+		}
+
+		if verbose {
+			state := tlsConn.ConnectionState()
+			logf("TCP: TLS established with %s (%s, %s)", clientAddr, tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+			if len(state.PeerCertificates) > 0 {
+				logf("TCP: Client certificate from %s: %s", clientAddr, state.PeerCertificates[0].Subject)
+			}
+		}
+	}
+
+	var lines lineBuffer
 	buffer := make([]byte, 4096)
 	for {
 		n, err := conn.Read(buffer)
@@ -128,9 +296,24 @@ func handleTCPConnection(conn net.Conn, verbose bool) {
 				if verbose {
 					logf("TCP: Connection closed by %s", clientAddr)
 				}
+				stats.reason = "closed by client"
+				return
+			}
+			if reason := handle.expired(); reason != "" {
+				if verbose {
+					logf("TCP: Closing connection from %s: %s", clientAddr, reason)
+				}
+				stats.reason = reason
 				return
 			}
 			log.Printf("TCP: Error reading from %s: %v", clientAddr, err)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				metrics.failed("tcp", "timeout")
+				stats.reason = "idle timeout"
+			} else {
+				metrics.failed("tcp", "read")
+				stats.reason = "read error"
+			}
 			return
 		}
 
@@ -143,19 +326,38 @@ func handleTCPConnection(conn net.Conn, verbose bool) {
 		_, err = conn.Write(buffer[:n])
 		if err != nil {
 			log.Printf("TCP: Error writing to %s: %v", clientAddr, err)
+			metrics.failed("tcp", "write")
+			stats.reason = "write error"
 			return
 		}
+		stats.echoed(n)
 
 		if verbose {
 			logf("TCP: Echoed to %s: %q", clientAddr, message)
 		}
 
-		// Reset read deadline
+		for _, line := range lines.feed(buffer[:n]) {
+			tracker.observe("tcp", clientAddr, line)
+		}
+
+		// Reset read deadline, unless the connection was expired while echoing
 		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		if reason := handle.expired(); reason != "" {
+			if verbose {
+				logf("TCP: Closing connection from %s: %s", clientAddr, reason)
+			}
+			stats.reason = reason
+			return
+		}
 	}
 }
 
 func startUDPServer(config Config) {
+	if config.UDPPortRange != "" {
+		startUDPPortRange(config)
+		return
+	}
+
 	addr := fmt.Sprintf("0.0.0.0:%d", config.Port)
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
@@ -167,14 +369,82 @@ func startUDPServer(config Config) {
 		log.Fatalf("Failed to start UDP server: %v", err)
 	}
 	defer conn.Close()
+	connections.addListener(conn)
 
 	logf("UDP Echo Server listening on %s", addr)
 
+	serveUDP(conn, config)
+}
+
+// startUDPPortRange binds a UDP echo socket on every port in the configured range
+func startUDPPortRange(config Config) {
+	first, last, err := parsePortRange(config.UDPPortRange)
+	if err != nil {
+		log.Fatalf("Invalid --udp-port-range: %v", err)
+	}
+
+	bound := 0
+	for port := first; port <= last; port++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			log.Printf("UDP: Failed to bind port %d: %v", port, err)
+			continue
+		}
+		bound++
+		connections.addListener(conn)
+
+		go func() {
+			defer conn.Close()
+			serveUDP(conn, config)
+		}()
+	}
+
+	if bound == 0 {
+		log.Fatalf("Failed to bind any UDP port in range %d-%d", first, last)
+	}
+	logf("UDP Echo Server listening on 0.0.0.0:%d-%d (%d of %d ports)", first, last, bound, last-first+1)
+}
+
+// parsePortRange parses a "first-last" port range
+func parsePortRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected FIRST-LAST, got %q", value)
+	}
+
+	first, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid first port %q", parts[0])
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid last port %q", parts[1])
+	}
+
+	if first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("range %d-%d is out of bounds", first, last)
+	}
+
+	return first, last, nil
+}
+
+// serveUDP echoes datagrams received on conn until it is closed
+func serveUDP(conn *net.UDPConn, config Config) {
 	buffer := make([]byte, 4096)
 	for {
 		n, clientAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Printf("UDP: Error reading: %v", err)
+			metrics.failed("udp", "read")
+			continue
+		}
+
+		// RTP is binary and always answered symmetrically, so it skips the reply address header
+		if config.RTP {
+			rtpEcho.handle(conn, buffer[:n], clientAddr, config)
 			continue
 		}
 
@@ -194,11 +464,15 @@ func startUDPServer(config Config) {
 		_, err = conn.WriteToUDP([]byte(actualMessage), replyAddr)
 		if err != nil {
 			log.Printf("UDP: Error writing to %s: %v", replyAddr, err)
+			metrics.failed("udp", "write")
 			continue
 		}
+		metrics.echoed("udp", len(actualMessage))
 
 		if config.Verbose {
 			logf("UDP: Echoed to %s: %q", replyAddr, actualMessage)
 		}
+
+		tracker.observe("udp", replyAddr.String(), actualMessage)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testSource = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+
+func TestParseUDPMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		addr    string // "" means the source address
+		echo    string
+	}{
+		{"IPv4", "203.0.113.5:9999\nhello", "203.0.113.5:9999", "hello"},
+		{"bracketed IPv6", "[2001:db8::1]:9999\nhello", "[2001:db8::1]:9999", "hello"},
+		{"unbracketed IPv6", "2001:db8::1:9999\nhello", "", "2001:db8::1:9999\nhello"},
+		{"port 0", "203.0.113.5:0\nhello", "", "203.0.113.5:0\nhello"},
+		{"unspecified IP", "0.0.0.0:9999\nhello", "", "0.0.0.0:9999\nhello"},
+		{"unspecified IPv6 with zone", "[::%eth0]:9999\nhello", "", "[::%eth0]:9999\nhello"},
+		{"IPv4-mapped unspecified", "[::ffff:0.0.0.0]:9999\nhello", "", "[::ffff:0.0.0.0]:9999\nhello"},
+		{"hostname", "localhost:9999\nhello", "", "localhost:9999\nhello"},
+		{"empty first line", "\nhello", "", "\nhello"},
+		{"no header", "hello", "", "hello"},
+		{"empty echo", "203.0.113.5:9999\n", "203.0.113.5:9999", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, echo := parseUDPMessage(tt.message, testSource)
+
+			want := testSource.String()
+			if tt.addr != "" {
+				want = tt.addr
+			}
+			if addr.String() != want {
+				t.Errorf("reply address = %s, want %s", addr, want)
+			}
+			if echo != tt.echo {
+				t.Errorf("echo = %q, want %q", echo, tt.echo)
+			}
+		})
+	}
+}
+
+func FuzzParseUDPMessage(f *testing.F) {
+	for _, seed := range []string{
+		"203.0.113.5:9999\nhello",
+		"[2001:db8::1]:9999\nhello",
+		"[fe80::1%eth0]:9999\nhello",
+		"[::ffff:192.0.2.1]:9999\nhello",
+		"2001:db8::1:9999\nhello",
+		"203.0.113.5:0\nhello",
+		"localhost:9999\nhello",
+		"\nhello",
+		"",
+		"\n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, message string) {
+		addr, echo := parseUDPMessage(message, testSource)
+		if addr == nil {
+			t.Fatalf("nil reply address for %q", message)
+		}
+
+		if addr == testSource {
+			if echo != message {
+				t.Fatalf("fallback echo = %q, want the whole message %q", echo, message)
+			}
+			return
+		}
+
+		// A custom reply address must come from a literal IP:port first line
+		firstLine, rest, ok := strings.Cut(message, "\n")
+		if !ok {
+			t.Fatalf("custom reply address %s from a message without a header: %q", addr, message)
+		}
+		if echo != rest {
+			t.Fatalf("echo = %q, want %q", echo, rest)
+		}
+		parsed, err := netip.ParseAddrPort(firstLine)
+		if err != nil {
+			t.Fatalf("custom reply address %s from unparseable header %q", addr, firstLine)
+		}
+		if addr.AddrPort() != parsed {
+			t.Fatalf("reply address = %s, header says %s", addr, parsed)
+		}
+		if addr.Port == 0 || addr.IP.IsUnspecified() {
+			t.Fatalf("unusable reply address %s accepted", addr)
+		}
+	})
+}
+
+// rtpPacket builds an RTP packet with the given first byte, payload type, sequence number and SSRC
+func rtpPacket(first, payloadType byte, seq uint16, ssrc uint32, rest ...byte) []byte {
+	packet := make([]byte, rtpHeaderSize, rtpHeaderSize+len(rest))
+	packet[0] = first
+	packet[1] = payloadType
+	binary.BigEndian.PutUint16(packet[2:4], seq)
+	binary.BigEndian.PutUint32(packet[8:12], ssrc)
+	return append(packet, rest...)
+}
+
+func FuzzParseRTPHeader(f *testing.F) {
+	f.Add(rtpPacket(0x80, 0, 1, 0x1234, 0xff, 0xff))                          // PCMU
+	f.Add(rtpPacket(0x80, 0x80|111, 65535, 0xdeadbeef))                       // Opus with marker, no payload
+	f.Add(rtpPacket(0x82, 8, 7, 1, 0, 0, 0, 1, 0, 0, 0, 2, 0xd5))             // Two CSRCs
+	f.Add(rtpPacket(0x81, 0, 7, 1, 0, 0, 0))                                  // Truncated CSRC list
+	f.Add(rtpPacket(0x90, 0, 7, 1, 0xbe, 0xde, 0, 1, 0x10, 0xaa, 0, 0, 0xff)) // One-byte header extension
+	f.Add(rtpPacket(0x90, 0, 7, 1, 0xbe, 0xde, 0, 4, 0x10))                   // Truncated header extension
+	f.Add(rtpPacket(0xa0, 0, 7, 1, 0xff, 0, 0, 3))                            // Padding
+	f.Add(rtpPacket(0xa0, 0, 7, 1, 0xff, 0, 0, 0))                            // Zero padding length
+	f.Add(rtpPacket(0xa0, 0, 7, 1, 0xff, 0, 0, 200))                          // Padding longer than the packet
+	f.Add(rtpPacket(0x80, 200, 7, 1, 0, 0, 0, 0))                             // RTCP sender report
+	f.Add(rtpPacket(0x40, 0, 7, 1))                                           // Version 1
+	f.Add([]byte{0x80, 0})                                                    // Too short
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		ssrc, seq, payloadType, err := parseRTPHeader(packet)
+		if err != nil {
+			return
+		}
+
+		if len(packet) < rtpHeaderSize {
+			t.Fatalf("accepted %d-byte packet", len(packet))
+		}
+		if packet[0]>>6 != 2 {
+			t.Fatalf("accepted RTP version %d", packet[0]>>6)
+		}
+		if payloadType >= 72 && payloadType <= 76 {
+			t.Fatalf("accepted RTCP packet type %d", packet[1])
+		}
+		if payloadType != packet[1]&0x7f || seq != binary.BigEndian.Uint16(packet[2:4]) || ssrc != binary.BigEndian.Uint32(packet[8:12]) {
+			t.Fatalf("header fields = (%d, %d, %08x), packet says (%d, %d, %08x)",
+				payloadType, seq, ssrc, packet[1]&0x7f, binary.BigEndian.Uint16(packet[2:4]), binary.BigEndian.Uint32(packet[8:12]))
+		}
+		if headerSize := rtpHeaderSize + int(packet[0]&0x0f)*4; len(packet) < headerSize {
+			t.Fatalf("accepted packet with truncated CSRC list")
+		}
+	})
+}
+
+func TestSequenceTrackerKeysByClient(t *testing.T) {
+	tr := &sequenceTracker{streams: make(map[string]*streamState)}
+	tr.observe("udp", "192.0.2.1:1000", "SEQ abc 0 1 x\n")
+	tr.observe("udp", "192.0.2.1:1000", "SEQ abc 1 1 x\n")
+	tr.observe("udp", "192.0.2.2:1000", "SEQ abc 0 1 x\n")
+
+	if len(tr.streams) != 2 {
+		t.Fatalf("%d streams tracked, want 2", len(tr.streams))
+	}
+	if s := tr.streams[streamKey("192.0.2.1:1000", "abc")]; s == nil || s.received != 2 || s.duplicates != 0 {
+		t.Errorf("first client's stream = %+v", s)
+	}
+	if s := tr.streams[streamKey("192.0.2.2:1000", "abc")]; s == nil || s.received != 1 || s.duplicates != 0 {
+		t.Errorf("second client's stream = %+v", s)
+	}
+
+	// The end marker only finishes the sender's own stream
+	tr.observe("udp", "192.0.2.2:1000", "SEQEND abc 1\n")
+	if len(tr.streams) != 1 || tr.streams[streamKey("192.0.2.1:1000", "abc")] == nil {
+		t.Errorf("streams after SEQEND = %v", tr.streams)
+	}
+}
+
+func TestSequenceTrackerBounds(t *testing.T) {
+	tr := &sequenceTracker{streams: make(map[string]*streamState)}
+
+	for seq := uint64(0); seq < 20000; seq++ {
+		tr.record("udp", "192.0.2.1:1000", "long", seq)
+	}
+	s := tr.streams[streamKey("192.0.2.1:1000", "long")]
+	if len(s.seen) > 4*seenWindow+1 {
+		t.Errorf("%d sequence numbers remembered", len(s.seen))
+	}
+	if s.received != 20000 || s.duplicates != 0 || s.reordered != 0 {
+		t.Errorf("stream = received %d, duplicates %d, reordered %d", s.received, s.duplicates, s.reordered)
+	}
+
+	// A recent duplicate is still recognized
+	tr.record("udp", "192.0.2.1:1000", "long", 19990)
+	if s.duplicates != 1 {
+		t.Errorf("duplicates = %d, want 1", s.duplicates)
+	}
+
+	for i := 0; i < maxTrackedStreams+10; i++ {
+		tr.record("udp", "192.0.2.1:1000", strconv.Itoa(i), 0)
+	}
+	if len(tr.streams) != maxTrackedStreams {
+		t.Errorf("%d streams tracked, want at most %d", len(tr.streams), maxTrackedStreams)
+	}
+}
+
+func TestSentEstimate(t *testing.T) {
+	tests := []struct {
+		highest uint64
+		sent    int
+	}{
+		{0, 1},
+		{499, 500},
+		{math.MaxInt - 1, math.MaxInt},
+		{math.MaxInt, math.MaxInt},
+		{math.MaxUint64, math.MaxInt},
+	}
+
+	for _, tt := range tests {
+		s := &streamState{highest: tt.highest}
+		if sent := s.sentEstimate(); sent != tt.sent {
+			t.Errorf("highest %d: sent estimate %d, want %d", tt.highest, sent, tt.sent)
+		}
+	}
+}
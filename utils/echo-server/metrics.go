@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverMetrics holds the counters exposed on the metrics endpoint, keyed by protocol
+type serverMetrics struct {
+	mu        sync.Mutex
+	startTime time.Time
+	accepted  map[string]int64
+	active    map[string]int64
+	messages  map[string]int64
+	bytes     map[string]int64
+	errors    map[[2]string]int64 // protocol, error type
+}
+
+var metrics = &serverMetrics{
+	startTime: time.Now(),
+	accepted:  make(map[string]int64),
+	active:    make(map[string]int64),
+	messages:  make(map[string]int64),
+	bytes:     make(map[string]int64),
+	errors:    make(map[[2]string]int64),
+}
+
+// accessLog enables one log line per finished connection
+var accessLog bool
+
+// connStats accumulates what happened on a single connection for metrics and the access log
+type connStats struct {
+	protocol   string
+	clientAddr string
+	start      time.Time
+	messages   atomic.Int64 // QUIC streams update these concurrently
+	bytes      atomic.Int64
+	reason     string // why the connection ended, set by the handler before close
+}
+
+// connectionOpened counts a new connection and returns its stats
+func (m *serverMetrics) connectionOpened(protocol, clientAddr string) *connStats {
+	m.mu.Lock()
+	m.accepted[protocol]++
+	m.active[protocol]++
+	m.mu.Unlock()
+
+	return &connStats{protocol: protocol, clientAddr: clientAddr, start: time.Now(), reason: "closed"}
+}
+
+// echoed counts one echoed message of n bytes
+func (m *serverMetrics) echoed(protocol string, n int) {
+	m.mu.Lock()
+	m.messages[protocol]++
+	m.bytes[protocol] += int64(n)
+	m.mu.Unlock()
+}
+
+// failed counts an error of the given type, e.g. "read" or "tls_handshake"
+func (m *serverMetrics) failed(protocol, errorType string) {
+	m.mu.Lock()
+	m.errors[[2]string{protocol, errorType}]++
+	m.mu.Unlock()
+}
+
+// echoed counts an echoed message on this connection
+func (s *connStats) echoed(n int) {
+	s.messages.Add(1)
+	s.bytes.Add(int64(n))
+	metrics.echoed(s.protocol, n)
+}
+
+// close marks the connection as finished and writes its access log line
+func (s *connStats) close() {
+	metrics.mu.Lock()
+	metrics.active[s.protocol]--
+	metrics.mu.Unlock()
+
+	if accessLog {
+		logf("Access: %s %s duration=%s messages=%d bytes=%d closed=%q",
+			s.protocol, s.clientAddr, time.Since(s.start).Round(time.Millisecond), s.messages.Load(), s.bytes.Load(), s.reason)
+	}
+}
+
+func startMetricsServer(config Config) {
+	addr := fmt.Sprintf("0.0.0.0:%d", config.MetricsPort)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.write(w)
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// The metrics server is deliberately not registered with the connection
+	// tracker, so it keeps answering scrapes while connections drain
+	logf("Metrics available on http://%s/metrics", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("Failed to start metrics server: %v", err)
+	}
+}
+
+// write renders the counters in the Prometheus text exposition format
+func (m *serverMetrics) write(w http.ResponseWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	writeFamily := func(name, kind, help string, values map[string]int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		protocols := make([]string, 0, len(values))
+		for protocol := range values {
+			protocols = append(protocols, protocol)
+		}
+		sort.Strings(protocols)
+		for _, protocol := range protocols {
+			fmt.Fprintf(&b, "%s{protocol=%q} %d\n", name, protocol, values[protocol])
+		}
+	}
+
+	writeFamily("echo_server_connections_accepted_total", "counter", "Connections accepted.", m.accepted)
+	writeFamily("echo_server_connections_active", "gauge", "Connections currently open.", m.active)
+	writeFamily("echo_server_messages_echoed_total", "counter", "Messages echoed back to clients.", m.messages)
+	writeFamily("echo_server_bytes_echoed_total", "counter", "Payload bytes echoed back to clients.", m.bytes)
+
+	b.WriteString("# HELP echo_server_errors_total Errors by protocol and type.\n# TYPE echo_server_errors_total counter\n")
+	keys := make([][2]string, 0, len(m.errors))
+	for key := range m.errors {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "echo_server_errors_total{protocol=%q,type=%q} %d\n", key[0], key[1], m.errors[key])
+	}
+
+	b.WriteString("# HELP echo_server_start_time_seconds Unix time the server started.\n# TYPE echo_server_start_time_seconds gauge\n")
+	fmt.Fprintf(&b, "echo_server_start_time_seconds %d\n", m.startTime.Unix())
+
+	w.Write([]byte(b.String()))
+}
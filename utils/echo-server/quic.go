@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN is the application protocol negotiated by the QUIC echo endpoint
+const quicALPN = "echo-quic"
+
+func startQUICServer(config Config) {
+	addr := fmt.Sprintf("0.0.0.0:%d", config.QUICPort)
+
+	var tlsConfig *tls.Config
+	var err error
+	if config.TLSCert != "" {
+		tlsConfig, err = loadTLSConfig(config)
+	} else {
+		// QUIC cannot run without TLS, so fall back to a throwaway certificate
+		tlsConfig, err = selfSignedTLSConfig()
+		logf("QUIC: No --tls-cert given, using an ephemeral self-signed certificate (clients need --tls-insecure)")
+	}
+	if err != nil {
+		log.Fatalf("Failed to configure TLS for QUIC: %v", err)
+	}
+	tlsConfig.MinVersion = tls.VersionTLS13
+	tlsConfig.NextProtos = []string{quicALPN}
+
+	listener, err := quic.ListenAddrEarly(addr, tlsConfig, &quic.Config{
+		Allow0RTT:      true,
+		MaxIdleTimeout: 30 * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start QUIC server: %v", err)
+	}
+	defer listener.Close()
+	connections.addListener(listener)
+
+	logf("QUIC Echo Server listening on %s (ALPN %q, 0-RTT enabled)", addr, quicALPN)
+
+	for {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				return
+			}
+			log.Printf("Failed to accept QUIC connection: %v", err)
+			metrics.failed("quic", "accept")
+			continue
+		}
+
+		go handleQUICConnection(conn, config.Verbose)
+	}
+}
+
+// quicSession tracks in-flight streams so an expired connection is closed once they finish
+type quicSession struct {
+	conn   *quic.Conn
+	mu     sync.Mutex
+	active int
+	reason string
+}
+
+// expire closes the connection now if idle, otherwise after the last stream completes
+func (s *quicSession) expire(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reason = reason
+	if s.active == 0 {
+		s.conn.CloseWithError(0, reason)
+	}
+}
+
+func (s *quicSession) streamStarted() {
+	s.mu.Lock()
+	s.active++
+	s.mu.Unlock()
+}
+
+func (s *quicSession) streamFinished() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active--
+	if s.active == 0 && s.reason != "" {
+		s.conn.CloseWithError(0, s.reason)
+	}
+}
+
+func handleQUICConnection(conn *quic.Conn, verbose bool) {
+	session := &quicSession{conn: conn}
+
+	handle, ok := connections.track(
+		session.expire,
+		func() { conn.CloseWithError(0, "server shutting down") },
+	)
+	if !ok {
+		conn.CloseWithError(0, "server shutting down")
+		return
+	}
+	defer handle.done()
+
+	clientAddr := conn.RemoteAddr().String()
+	if verbose {
+		logf("QUIC: New connection from %s", clientAddr)
+	}
+
+	stats := metrics.connectionOpened("quic", clientAddr)
+	defer stats.close()
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			if verbose {
+				logf("QUIC: Connection from %s closed: %v", clientAddr, err)
+			}
+			stats.reason = quicCloseReason(err)
+			return
+		}
+
+		session.streamStarted()
+		go func() {
+			defer session.streamFinished()
+			handleQUICStream(conn, stream, stats, verbose)
+		}()
+	}
+}
+
+func handleQUICStream(conn *quic.Conn, stream *quic.Stream, stats *connStats, verbose bool) {
+	defer stream.Close()
+
+	clientAddr := conn.RemoteAddr().String()
+
+	// Streams accepted before the handshake completes carry 0-RTT data
+	select {
+	case <-conn.HandshakeComplete():
+	default:
+		if verbose {
+			logf("QUIC: Stream %d from %s opened with 0-RTT data", stream.StreamID(), clientAddr)
+		}
+	}
+
+	buffer := make([]byte, 4096)
+	for {
+		stream.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		n, err := stream.Read(buffer)
+		if n > 0 {
+			if verbose {
+				logf("QUIC: Received on stream %d from %s: %q", stream.StreamID(), clientAddr, buffer[:n])
+			}
+
+			if _, werr := stream.Write(buffer[:n]); werr != nil {
+				log.Printf("QUIC: Error writing to %s: %v", clientAddr, werr)
+				metrics.failed("quic", "write")
+				return
+			}
+			stats.echoed(n)
+
+			if verbose {
+				logf("QUIC: Echoed on stream %d to %s: %q", stream.StreamID(), clientAddr, buffer[:n])
+			}
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("QUIC: Error reading from %s: %v", clientAddr, err)
+				metrics.failed("quic", "read")
+			}
+			return
+		}
+	}
+}
+
+// quicCloseReason describes why AcceptStream stopped, for the access log
+func quicCloseReason(err error) string {
+	var appErr *quic.ApplicationError
+	var idleErr *quic.IdleTimeoutError
+	switch {
+	case errors.As(err, &appErr) && appErr.Remote:
+		return "closed by client"
+	case errors.As(err, &idleErr):
+		metrics.failed("quic", "timeout")
+		return "idle timeout"
+	default:
+		return err.Error()
+	}
+}
+
+// selfSignedTLSConfig generates an in-memory certificate for QUIC when none is configured
+func selfSignedTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "echo-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, nil
+}
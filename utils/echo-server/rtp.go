@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const rtpHeaderSize = 12
+
+// rtpStream is the state kept per incoming RTP stream (source address + SSRC)
+type rtpStream struct {
+	seqOffset uint16
+	packets   int
+	lastSeen  time.Time
+}
+
+// rtpEchoer echoes validated RTP packets back to their source, the way a
+// symmetric-RTP media server would
+type rtpEchoer struct {
+	mu      sync.Mutex
+	streams map[string]*rtpStream
+}
+
+var rtpEcho = &rtpEchoer{streams: make(map[string]*rtpStream)}
+
+// parseRTPHeader validates an RTP packet and returns its SSRC, sequence number and payload type
+func parseRTPHeader(packet []byte) (ssrc uint32, seq uint16, payloadType uint8, err error) {
+	if len(packet) < rtpHeaderSize {
+		return 0, 0, 0, fmt.Errorf("packet too short (%d bytes)", len(packet))
+	}
+	if version := packet[0] >> 6; version != 2 {
+		return 0, 0, 0, fmt.Errorf("unsupported RTP version %d", version)
+	}
+
+	// RTCP shares the port when rtcp-mux is used; its packet types land in 72-76 here
+	payloadType = packet[1] & 0x7f
+	if payloadType >= 72 && payloadType <= 76 {
+		return 0, 0, 0, fmt.Errorf("RTCP packet (type %d)", packet[1])
+	}
+
+	headerSize := rtpHeaderSize + int(packet[0]&0x0f)*4
+	if len(packet) < headerSize {
+		return 0, 0, 0, fmt.Errorf("truncated CSRC list")
+	}
+
+	if packet[0]&0x10 != 0 {
+		if len(packet) < headerSize+4 {
+			return 0, 0, 0, fmt.Errorf("truncated header extension")
+		}
+		headerSize += 4 + int(binary.BigEndian.Uint16(packet[headerSize+2:headerSize+4]))*4
+		if len(packet) < headerSize {
+			return 0, 0, 0, fmt.Errorf("truncated header extension")
+		}
+	}
+
+	if packet[0]&0x20 != 0 {
+		padding := int(packet[len(packet)-1])
+		if padding == 0 || headerSize+padding > len(packet) {
+			return 0, 0, 0, fmt.Errorf("invalid padding length %d", padding)
+		}
+	}
+
+	ssrc = binary.BigEndian.Uint32(packet[8:12])
+	seq = binary.BigEndian.Uint16(packet[2:4])
+	return ssrc, seq, payloadType, nil
+}
+
+// handle validates one packet, rewrites it as configured and echoes it after --rtp-delay
+func (e *rtpEchoer) handle(conn *net.UDPConn, data []byte, clientAddr *net.UDPAddr, config Config) {
+	ssrc, seq, payloadType, err := parseRTPHeader(data)
+	if err != nil {
+		if config.Verbose {
+			logf("RTP: Dropping packet from %s: %v", clientAddr, err)
+		}
+		metrics.failed("udp", "invalid_rtp")
+		return
+	}
+
+	stream := e.stream(clientAddr, ssrc, payloadType, config.Verbose)
+
+	// The read buffer is reused, so the delayed echo needs its own copy
+	packet := make([]byte, len(data))
+	copy(packet, data)
+
+	if config.RTPRewriteSeq {
+		binary.BigEndian.PutUint16(packet[2:4], seq+stream.seqOffset)
+	}
+	if config.RTPSSRC != 0 {
+		binary.BigEndian.PutUint32(packet[8:12], uint32(config.RTPSSRC))
+	}
+
+	echo := func() {
+		if _, err := conn.WriteToUDP(packet, clientAddr); err != nil {
+			log.Printf("RTP: Error writing to %s: %v", clientAddr, err)
+			metrics.failed("udp", "write")
+			return
+		}
+		metrics.echoed("udp", len(packet))
+	}
+
+	if config.RTPDelay > 0 {
+		time.AfterFunc(config.RTPDelay, echo)
+	} else {
+		echo()
+	}
+}
+
+// stream returns the state for a source address and SSRC, creating it on the first packet
+func (e *rtpEchoer) stream(clientAddr *net.UDPAddr, ssrc uint32, payloadType uint8, verbose bool) *rtpStream {
+	key := fmt.Sprintf("%s/%08x", clientAddr, ssrc)
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stream, ok := e.streams[key]
+	if !ok {
+		// Forget streams that went quiet, so long-running servers don't accumulate state
+		for k, s := range e.streams {
+			if now.Sub(s.lastSeen) > streamIdleTimeout {
+				if verbose {
+					logf("RTP: Stream %s ended after %d packets", k, s.packets)
+				}
+				delete(e.streams, k)
+			}
+		}
+
+		stream = &rtpStream{seqOffset: uint16(rand.Intn(1 << 16))}
+		e.streams[key] = stream
+		if verbose {
+			logf("RTP: New stream SSRC %08x from %s (payload type %d)", ssrc, clientAddr, payloadType)
+		}
+	}
+
+	stream.packets++
+	stream.lastSeen = now
+	return stream
+}
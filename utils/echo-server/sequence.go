@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Continuous mode messages sent by echo-client are newline-terminated lines:
+//
+//	SEQ <stream-id> <seq> <send-time-ns> <padding>
+//	SEQEND <stream-id> <sent-count>
+//
+// They are echoed like any other payload; the server additionally tracks
+// sequence continuity per stream and logs a report when the stream ends.
+const (
+	seqPrefix    = "SEQ "
+	seqEndPrefix = "SEQEND "
+
+	streamIdleTimeout = 60 * time.Second
+
+	// Bounds on tracker state, since any client can start streams with arbitrary ids and sequence numbers
+	maxTrackedStreams = 1024
+	seenWindow        = 1024
+)
+
+type streamState struct {
+	protocol   string
+	clientAddr string
+	streamID   string
+	received   int
+	duplicates int
+	reordered  int
+	highest    uint64
+	seen       map[uint64]bool
+	lastSeen   time.Time
+}
+
+// sequenceTracker validates continuous mode streams across all protocols
+type sequenceTracker struct {
+	mu      sync.Mutex
+	streams map[string]*streamState // keyed by client address and stream id
+	full    bool                    // whether the last new stream was refused for being over maxTrackedStreams
+}
+
+var tracker = newSequenceTracker()
+
+func newSequenceTracker() *sequenceTracker {
+	t := &sequenceTracker{streams: make(map[string]*streamState)}
+	go t.reapIdleStreams()
+	return t
+}
+
+// observe inspects a single message and updates stream statistics if it is a sequence message
+func (t *sequenceTracker) observe(protocol, clientAddr, message string) {
+	message = strings.TrimSuffix(message, "\n")
+
+	switch {
+	case strings.HasPrefix(message, seqPrefix):
+		fields := strings.Fields(message)
+		if len(fields) < 4 {
+			return
+		}
+		seq, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return
+		}
+		t.record(protocol, clientAddr, fields[1], seq)
+
+	case strings.HasPrefix(message, seqEndPrefix):
+		fields := strings.Fields(message)
+		if len(fields) < 3 {
+			return
+		}
+		sent, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		t.finish(clientAddr, fields[1], sent)
+	}
+}
+
+func (t *sequenceTracker) record(protocol, clientAddr, streamID string, seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := streamKey(clientAddr, streamID)
+	state, ok := t.streams[key]
+	if !ok {
+		if len(t.streams) >= maxTrackedStreams {
+			if !t.full {
+				logf("%s: Not tracking continuous stream %s from %s: already tracking %d streams", strings.ToUpper(protocol), streamID, clientAddr, len(t.streams))
+				t.full = true
+			}
+			return
+		}
+		t.full = false
+
+		state = &streamState{
+			protocol:   protocol,
+			clientAddr: clientAddr,
+			streamID:   streamID,
+			seen:       make(map[uint64]bool),
+		}
+		t.streams[key] = state
+		logf("%s: Continuous stream %s started by %s", strings.ToUpper(protocol), streamID, clientAddr)
+	}
+
+	state.lastSeen = time.Now()
+
+	if state.seen[seq] {
+		state.duplicates++
+		return
+	}
+	state.seen[seq] = true
+	state.received++
+
+	if seq < state.highest {
+		state.reordered++
+	} else {
+		state.highest = seq
+	}
+
+	// Forget old sequence numbers so long streams do not grow the map without bound
+	if len(state.seen) > 4*seenWindow && state.highest > seenWindow {
+		for s := range state.seen {
+			if s < state.highest-seenWindow {
+				delete(state.seen, s)
+			}
+		}
+	}
+}
+
+func (t *sequenceTracker) finish(clientAddr, streamID string, sent int) {
+	key := streamKey(clientAddr, streamID)
+
+	t.mu.Lock()
+	state, ok := t.streams[key]
+	delete(t.streams, key)
+	t.mu.Unlock()
+
+	if ok {
+		reportStream(state, sent, "completed")
+	}
+}
+
+// streamKey identifies a stream by its client, so clients reusing an id do not share statistics
+func streamKey(clientAddr, streamID string) string {
+	return clientAddr + "/" + streamID
+}
+
+// reapIdleStreams reports and forgets streams whose end marker never arrived
+func (t *sequenceTracker) reapIdleStreams() {
+	ticker := time.NewTicker(streamIdleTimeout / 4)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		for key, state := range t.streams {
+			if time.Since(state.lastSeen) > streamIdleTimeout {
+				delete(t.streams, key)
+				reportStream(state, state.sentEstimate(), "timed out")
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// sentEstimate guesses how many messages were sent when the end marker never arrived
+func (s *streamState) sentEstimate() int {
+	if s.highest >= math.MaxInt {
+		return math.MaxInt
+	}
+	return int(s.highest) + 1
+}
+
+func reportStream(state *streamState, sent int, outcome string) {
+	lost := sent - state.received
+	if lost < 0 {
+		lost = 0
+	}
+
+	logf("%s: Continuous stream %s from %s %s: received %d of %d, lost %d, reordered %d, duplicates %d",
+		strings.ToUpper(state.protocol), state.streamID, state.clientAddr, outcome,
+		state.received, sent, lost, state.reordered, state.duplicates)
+}
+
+// lineBuffer reassembles newline-terminated messages from a TCP byte stream
+type lineBuffer struct {
+	pending []byte
+}
+
+// feed appends data and returns any complete lines it contains
+func (b *lineBuffer) feed(data []byte) []string {
+	b.pending = append(b.pending, data...)
+
+	var lines []string
+	for {
+		idx := bytes.IndexByte(b.pending, '\n')
+		if idx == -1 {
+			break
+		}
+		lines = append(lines, string(b.pending[:idx]))
+		b.pending = b.pending[idx+1:]
+	}
+
+	// Plain echo traffic may never contain a newline, so don't buffer it forever
+	if len(b.pending) > 64*1024 {
+		b.pending = b.pending[:0]
+	}
+
+	return lines
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// Accept connections from any page so the endpoint can be probed from a browser
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func startWSServer(config Config) {
+	addr := fmt.Sprintf("0.0.0.0:%d", config.WSPort)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(config.WSPath, func(w http.ResponseWriter, r *http.Request) {
+		handleWSConnection(w, r, config)
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	connections.addListener(server)
+
+	var err error
+	if config.TLSCert != "" {
+		tlsConfig, tlsErr := loadTLSConfig(config)
+		if tlsErr != nil {
+			log.Fatalf("Failed to configure TLS: %v", tlsErr)
+		}
+		server.TLSConfig = tlsConfig
+
+		logf("WebSocket Echo Server listening on wss://%s%s", addr, config.WSPath)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		logf("WebSocket Echo Server listening on ws://%s%s", addr, config.WSPath)
+		err = server.ListenAndServe()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Failed to start WebSocket server: %v", err)
+	}
+}
+
+func handleWSConnection(w http.ResponseWriter, r *http.Request, config Config) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response
+		log.Printf("WS: Failed to upgrade connection from %s: %v", r.RemoteAddr, err)
+		metrics.failed("ws", "upgrade")
+		return
+	}
+	defer conn.Close()
+
+	handle, ok := connections.track(
+		func(string) { conn.SetReadDeadline(time.Now()) },
+		func() { conn.Close() },
+	)
+	if !ok {
+		return
+	}
+	defer handle.done()
+
+	clientAddr := r.RemoteAddr
+	if config.Verbose {
+		logf("WS: New connection from %s (origin %q)", clientAddr, r.Header.Get("Origin"))
+	}
+
+	stats := metrics.connectionOpened("ws", clientAddr)
+	defer stats.close()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		if reason := handle.expired(); reason != "" {
+			closeWSConnection(conn, clientAddr, reason, config.Verbose)
+			stats.reason = reason
+			return
+		}
+
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			if reason := handle.expired(); reason != "" {
+				closeWSConnection(conn, clientAddr, reason, config.Verbose)
+				stats.reason = reason
+				return
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				if config.Verbose {
+					logf("WS: Connection closed by %s", clientAddr)
+				}
+				stats.reason = "closed by client"
+				return
+			}
+			log.Printf("WS: Error reading from %s: %v", clientAddr, err)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				metrics.failed("ws", "timeout")
+				stats.reason = "idle timeout"
+			} else {
+				metrics.failed("ws", "read")
+				stats.reason = "read error"
+			}
+			return
+		}
+
+		if config.Verbose {
+			logf("WS: Received %s frame from %s: %q", frameTypeName(messageType), clientAddr, message)
+		}
+
+		if config.WSDelay > 0 {
+			time.Sleep(config.WSDelay)
+		}
+
+		// Echo back with the same frame type
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			log.Printf("WS: Error writing to %s: %v", clientAddr, err)
+			metrics.failed("ws", "write")
+			stats.reason = "write error"
+			return
+		}
+		stats.echoed(len(message))
+
+		if config.Verbose {
+			logf("WS: Echoed to %s: %q", clientAddr, message)
+		}
+	}
+}
+
+// closeWSConnection tells the client the server is going away before closing
+func closeWSConnection(conn *websocket.Conn, clientAddr, reason string, verbose bool) {
+	if verbose {
+		logf("WS: Closing connection from %s: %s", clientAddr, reason)
+	}
+
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
+
+// frameTypeName returns a human-readable name for a WebSocket data frame type
+func frameTypeName(messageType int) string {
+	switch messageType {
+	case websocket.TextMessage:
+		return "text"
+	case websocket.BinaryMessage:
+		return "binary"
+	default:
+		return fmt.Sprintf("type %d", messageType)
+	}
+}
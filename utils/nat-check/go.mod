@@ -0,0 +1,7 @@
+module nat-check
+
+go 1.21
+
+require voip v0.0.0
+
+replace voip => ../voip
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"voip/netutil"
+)
+
+const (
+	stunHeaderSize     = 20
+	stunMagicCookie    = 0x2112A442
+	stunBindingRequest = 0x0001
+	stunBindingSuccess = 0x0101
+	stunAttrMapped     = 0x0001
+	stunAttrXORMapped  = 0x0020
+	stunAttrOtherAddr  = 0x802C
+	stunAttrSoftware   = 0x8022
+	stunFamilyIPv4     = 0x01
+	stunFamilyIPv6     = 0x02
+	defaultStunServers = "stun.l.google.com:19302,stun.cloudflare.com:3478,stun.nextcloud.com:443"
+	initialRetransmit  = 250 * time.Millisecond
+)
+
+type Config struct {
+	Servers   []string
+	LocalPort int
+	Timeout   int
+	Retries   int
+	Verbose   bool
+}
+
+// BindingResult is the outcome of one STUN binding request
+type BindingResult struct {
+	Server     string
+	ServerAddr *net.UDPAddr
+	MappedAddr *net.UDPAddr
+	OtherAddr  *net.UDPAddr
+	Software   string
+	RTT        time.Duration
+	Err        error
+}
+
+func main() {
+	var config Config
+	var serversFlag string
+
+	flag.StringVar(&serversFlag, "servers", defaultStunServers, "Comma-separated STUN servers (host:port)")
+	flag.IntVar(&config.LocalPort, "local-port", 0, "Local UDP port to send from (random if not specified)")
+	flag.IntVar(&config.Timeout, "timeout", 3, "Timeout per server in seconds")
+	flag.IntVar(&config.Retries, "retries", 3, "Retransmissions per server")
+	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
+	flag.Parse()
+
+	for _, server := range strings.Split(serversFlag, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			config.Servers = append(config.Servers, server)
+		}
+	}
+	if len(config.Servers) == 0 {
+		log.Fatalf("No STUN servers specified")
+	}
+
+	// All requests share one socket: comparing the mappings different servers
+	// see for the same local endpoint is what reveals the NAT mapping behavior
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: config.LocalPort})
+	if err != nil {
+		log.Fatalf("Failed to open UDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	localIP, err := netutil.DefaultRouteIP()
+	if err != nil && config.Verbose {
+		logf("Failed to detect local IP: %v", err)
+	}
+	localPort := conn.LocalAddr().(*net.UDPAddr).Port
+	logf("Local endpoint: %s:%d", localIP, localPort)
+
+	var results []BindingResult
+	for _, server := range config.Servers {
+		result := bindingRequest(conn, server, config)
+		results = append(results, result)
+
+		if result.Err != nil {
+			logf("%-28s error: %v", server, result.Err)
+			continue
+		}
+
+		logf("%-28s %-22s mapped %-22s rtt %s", server, result.ServerAddr, result.MappedAddr, result.RTT.Round(time.Millisecond))
+		if config.Verbose {
+			if result.Software != "" {
+				logf("%-28s software %q", server, result.Software)
+			}
+			if result.OtherAddr != nil {
+				logf("%-28s alternate address %s (RFC 5780 capable)", server, result.OtherAddr)
+			}
+		}
+	}
+
+	if !printVerdict(results, localIP, localPort) {
+		os.Exit(1)
+	}
+}
+
+// bindingRequest sends a STUN Binding request to server with retransmissions and waits for the response
+func bindingRequest(conn *net.UDPConn, server string, config Config) BindingResult {
+	result := BindingResult{Server: server}
+
+	serverAddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to resolve: %v", err)
+		return result
+	}
+	result.ServerAddr = serverAddr
+
+	transactionID := make([]byte, 12)
+	if _, err := rand.Read(transactionID); err != nil {
+		result.Err = fmt.Errorf("failed to generate transaction ID: %v", err)
+		return result
+	}
+
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(request[2:4], 0)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	copy(request[8:20], transactionID)
+
+	deadline := time.Now().Add(time.Duration(config.Timeout) * time.Second)
+	retransmit := initialRetransmit
+	buffer := make([]byte, 1500)
+
+	for attempt := 0; attempt <= config.Retries && time.Now().Before(deadline); attempt++ {
+		if config.Verbose && attempt > 0 {
+			logf("%-28s retransmitting (attempt %d)", server, attempt+1)
+		}
+
+		sentAt := time.Now()
+		if _, err := conn.WriteToUDP(request, serverAddr); err != nil {
+			result.Err = fmt.Errorf("failed to send: %v", err)
+			return result
+		}
+
+		// RFC 5389 section 7.2.1: double the retransmission timeout each attempt
+		waitUntil := sentAt.Add(retransmit)
+		if waitUntil.After(deadline) || attempt == config.Retries {
+			waitUntil = deadline
+		}
+		retransmit *= 2
+
+		for {
+			conn.SetReadDeadline(waitUntil)
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				result.Err = fmt.Errorf("failed to read: %v", err)
+				return result
+			}
+
+			mapped, other, software, err := parseBindingResponse(buffer[:n], transactionID)
+			if err != nil {
+				// Stray or late responses to earlier transactions are expected
+				if config.Verbose {
+					logf("%-28s ignoring packet from %s: %v", server, from, err)
+				}
+				continue
+			}
+
+			result.MappedAddr = mapped
+			result.OtherAddr = other
+			result.Software = software
+			result.RTT = time.Since(sentAt)
+			return result
+		}
+	}
+
+	result.Err = fmt.Errorf("no response within %d seconds", config.Timeout)
+	return result
+}
+
+// parseBindingResponse validates a Binding success response and extracts its address attributes
+func parseBindingResponse(packet []byte, transactionID []byte) (mapped, other *net.UDPAddr, software string, err error) {
+	if len(packet) < stunHeaderSize {
+		return nil, nil, "", fmt.Errorf("packet too short")
+	}
+	if binary.BigEndian.Uint32(packet[4:8]) != stunMagicCookie {
+		return nil, nil, "", fmt.Errorf("not a STUN packet")
+	}
+	if string(packet[8:20]) != string(transactionID) {
+		return nil, nil, "", fmt.Errorf("transaction ID mismatch")
+	}
+	if messageType := binary.BigEndian.Uint16(packet[0:2]); messageType != stunBindingSuccess {
+		return nil, nil, "", fmt.Errorf("unexpected message type 0x%04x", messageType)
+	}
+
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if stunHeaderSize+length > len(packet) {
+		return nil, nil, "", fmt.Errorf("truncated message")
+	}
+
+	var plainMapped *net.UDPAddr
+	attributes := packet[stunHeaderSize : stunHeaderSize+length]
+	for len(attributes) >= 4 {
+		attrType := binary.BigEndian.Uint16(attributes[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attributes[2:4]))
+		if 4+attrLength > len(attributes) {
+			return nil, nil, "", fmt.Errorf("truncated attribute 0x%04x", attrType)
+		}
+		value := attributes[4 : 4+attrLength]
+
+		switch attrType {
+		case stunAttrXORMapped:
+			mapped = parseAddress(value, packet[4:20])
+		case stunAttrMapped:
+			plainMapped = parseAddress(value, nil)
+		case stunAttrOtherAddr:
+			other = parseAddress(value, nil)
+		case stunAttrSoftware:
+			software = string(value)
+		}
+
+		// Attributes are padded to a multiple of 4 bytes
+		padded := (attrLength + 3) &^ 3
+		if 4+padded > len(attributes) {
+			break
+		}
+		attributes = attributes[4+padded:]
+	}
+
+	// Pre-RFC 5389 servers only send MAPPED-ADDRESS
+	if mapped == nil {
+		mapped = plainMapped
+	}
+	if mapped == nil {
+		return nil, nil, "", fmt.Errorf("response has no mapped address")
+	}
+
+	return mapped, other, software, nil
+}
+
+// parseAddress decodes a MAPPED-ADDRESS style attribute value, un-XORing it when xorKey is set
+func parseAddress(value []byte, xorKey []byte) *net.UDPAddr {
+	if len(value) < 4 {
+		return nil
+	}
+
+	family := value[1]
+	port := binary.BigEndian.Uint16(value[2:4])
+
+	var ipLength int
+	switch family {
+	case stunFamilyIPv4:
+		ipLength = net.IPv4len
+	case stunFamilyIPv6:
+		ipLength = net.IPv6len
+	default:
+		return nil
+	}
+	if len(value) < 4+ipLength {
+		return nil
+	}
+
+	ip := make(net.IP, ipLength)
+	copy(ip, value[4:4+ipLength])
+
+	if xorKey != nil {
+		// X-Port is XORed with the top of the magic cookie, X-Address with cookie + transaction ID
+		port ^= stunMagicCookie >> 16
+		for i := range ip {
+			ip[i] ^= xorKey[i]
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
+
+// printVerdict classifies the NAT from the collected mappings, returning false if no server answered
+func printVerdict(results []BindingResult, localIP string, localPort int) bool {
+	var ok []BindingResult
+	serverIPs := make(map[string]bool)
+	for _, result := range results {
+		if result.Err == nil {
+			ok = append(ok, result)
+			serverIPs[result.ServerAddr.IP.String()] = true
+		}
+	}
+
+	fmt.Println()
+	if len(ok) == 0 {
+		fmt.Println("✗ No STUN server answered: UDP is blocked or filtered. TURN over TCP/TLS will be needed.")
+		return false
+	}
+
+	first := ok[0].MappedAddr
+	fmt.Printf("Public endpoint:   %s\n", first)
+
+	if first.IP.String() == localIP && first.Port == localPort {
+		fmt.Println("NAT:               none (public address on a local interface)")
+		fmt.Println("Verdict:           direct media works; no symmetric RTP or TURN needed")
+		return true
+	}
+
+	if first.Port == localPort {
+		fmt.Println("Port preservation: yes")
+	} else {
+		fmt.Println("Port preservation: no")
+	}
+
+	if len(ok) < 2 || len(serverIPs) < 2 {
+		fmt.Println("Mapping:           unknown (need answers from at least two servers with different IPs)")
+		return true
+	}
+
+	independent := true
+	for _, result := range ok[1:] {
+		if result.MappedAddr.String() != first.String() {
+			independent = false
+		}
+	}
+
+	if independent {
+		fmt.Println("Mapping:           endpoint-independent (same mapping for every destination)")
+		fmt.Println("Verdict:           STUN-derived candidates work; symmetric RTP is enough and TURN should not be needed")
+	} else {
+		fmt.Println("Mapping:           address/port-dependent (symmetric NAT, mapping changes per destination)")
+		fmt.Println("Verdict:           advertised STUN addresses will not be reachable; SIP media needs symmetric RTP (comedia) on the far end and WebRTC needs TURN")
+	}
+
+	return true
+}
+
+// logf prints a timestamped log message
+func logf(format string, args ...interface{}) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+	fmt.Printf("[%s] %s\n", timestamp, fmt.Sprintf(format, args...))
+}
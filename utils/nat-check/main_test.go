@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// RFC 5769 sample responses, with the transaction ID b7e7a701bc34d686fa87dfae
+var (
+	testTransactionID = mustHex("b7e7a701bc34d686fa87dfae")
+
+	rfc5769IPv4Response = mustHex("0101003c2112a442b7e7a701bc34d686fa87dfae" +
+		"8022000b7465737420766563746f7220" +
+		"002000080001a147e112a643" +
+		"000800142b91f599fd9e90c38c7489f92af9ba53f06be7d7" +
+		"80280004c07d4c96")
+
+	rfc5769IPv6Response = mustHex("010100482112a442b7e7a701bc34d686fa87dfae" +
+		"8022000b7465737420766563746f7220" +
+		"002000140002a1470113a9faa5d3f179bc25f4b5bed2b9d9" +
+		"00080014a382954e4be67bf11784c97c8292c275bfe3ed41" +
+		"80280004c8fb0b4c")
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// stunResponse builds a message with the given type and attributes, fixing up the length
+func stunResponse(messageType uint16, attributes ...[]byte) []byte {
+	packet := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(packet[0:2], messageType)
+	binary.BigEndian.PutUint32(packet[4:8], stunMagicCookie)
+	copy(packet[8:20], testTransactionID)
+	for _, attr := range attributes {
+		packet = append(packet, attr...)
+	}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)-stunHeaderSize))
+	return packet
+}
+
+// stunAttr encodes an attribute, padded to a multiple of 4 bytes
+func stunAttr(attrType uint16, value []byte) []byte {
+	attr := make([]byte, 4, 4+len(value)+3)
+	binary.BigEndian.PutUint16(attr[0:2], attrType)
+	binary.BigEndian.PutUint16(attr[2:4], uint16(len(value)))
+	attr = append(attr, value...)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+	return attr
+}
+
+// plainAddress encodes an unXORed IPv4 MAPPED-ADDRESS style value
+func plainAddress(ip [4]byte, port uint16) []byte {
+	value := []byte{0, stunFamilyIPv4, byte(port >> 8), byte(port)}
+	return append(value, ip[:]...)
+}
+
+func TestParseBindingResponse(t *testing.T) {
+	xorMapped := mustHex("0001a147e112a643") // 192.0.2.1:32853
+
+	tests := []struct {
+		name     string
+		packet   []byte
+		mapped   string
+		other    string
+		software string
+		err      string
+	}{
+		{name: "RFC 5769 IPv4", packet: rfc5769IPv4Response, mapped: "192.0.2.1:32853", software: "test vector"},
+		{name: "RFC 5769 IPv6", packet: rfc5769IPv6Response, mapped: "[2001:db8:1234:5678:11:2233:4455:6677]:32853", software: "test vector"},
+		{
+			name:   "XOR-MAPPED-ADDRESS wins over MAPPED-ADDRESS",
+			packet: stunResponse(stunBindingSuccess, stunAttr(stunAttrMapped, plainAddress([4]byte{10, 0, 0, 1}, 1234)), stunAttr(stunAttrXORMapped, xorMapped)),
+			mapped: "192.0.2.1:32853",
+		},
+		{
+			name:   "MAPPED-ADDRESS only, from a pre-RFC 5389 server",
+			packet: stunResponse(stunBindingSuccess, stunAttr(stunAttrMapped, plainAddress([4]byte{203, 0, 113, 5}, 40000))),
+			mapped: "203.0.113.5:40000",
+		},
+		{
+			name:   "OTHER-ADDRESS",
+			packet: stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, xorMapped), stunAttr(stunAttrOtherAddr, plainAddress([4]byte{198, 51, 100, 2}, 3479))),
+			mapped: "192.0.2.1:32853",
+			other:  "198.51.100.2:3479",
+		},
+		{
+			name:   "unknown attributes are skipped",
+			packet: stunResponse(stunBindingSuccess, stunAttr(0x8023, []byte{1, 2, 3}), stunAttr(stunAttrXORMapped, xorMapped)),
+			mapped: "192.0.2.1:32853",
+		},
+		{
+			name: "unpadded last attribute",
+			packet: func() []byte {
+				packet := stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, xorMapped), stunAttr(stunAttrSoftware, []byte("abc")))
+				packet = packet[:len(packet)-1]
+				binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)-stunHeaderSize))
+				return packet
+			}(),
+			mapped:   "192.0.2.1:32853",
+			software: "abc",
+		},
+		{
+			name:   "unknown address family",
+			packet: stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, mustHex("0003a147e112a643"))),
+			err:    "no mapped address",
+		},
+		{
+			name:   "IPv6 family with an IPv4-sized address",
+			packet: stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, mustHex("0002a147e112a643"))),
+			err:    "no mapped address",
+		},
+		{
+			name:   "address value too short",
+			packet: stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, []byte{0, 1})),
+			err:    "no mapped address",
+		},
+		{name: "no attributes", packet: stunResponse(stunBindingSuccess), err: "no mapped address"},
+		{
+			name: "truncated attribute",
+			packet: func() []byte {
+				packet := stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, xorMapped))
+				binary.BigEndian.PutUint16(packet[stunHeaderSize+2:stunHeaderSize+4], 16)
+				return packet
+			}(),
+			err: "truncated attribute 0x0020",
+		},
+		{name: "truncated message", packet: rfc5769IPv4Response[:len(rfc5769IPv4Response)-4], err: "truncated message"},
+		{name: "too short", packet: rfc5769IPv4Response[:stunHeaderSize-1], err: "packet too short"},
+		{
+			name: "wrong magic cookie",
+			packet: func() []byte {
+				packet := stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, xorMapped))
+				packet[4] = 0
+				return packet
+			}(),
+			err: "not a STUN packet",
+		},
+		{
+			name: "other transaction",
+			packet: func() []byte {
+				packet := stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, xorMapped))
+				packet[19] ^= 0xff
+				return packet
+			}(),
+			err: "transaction ID mismatch",
+		},
+		{name: "error response", packet: stunResponse(0x0111, stunAttr(stunAttrXORMapped, xorMapped)), err: "unexpected message type 0x0111"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapped, other, software, err := parseBindingResponse(tt.packet, testTransactionID)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if mapped.String() != tt.mapped {
+				t.Errorf("mapped = %s, want %s", mapped, tt.mapped)
+			}
+			if tt.other == "" && other != nil {
+				t.Errorf("other = %s, want none", other)
+			}
+			if tt.other != "" && other.String() != tt.other {
+				t.Errorf("other = %v, want %s", other, tt.other)
+			}
+			if software != tt.software {
+				t.Errorf("software = %q, want %q", software, tt.software)
+			}
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	key := append(mustHex("2112a442"), testTransactionID...)
+
+	tests := []struct {
+		name  string
+		value []byte
+		key   []byte
+		addr  string // "" means nil
+	}{
+		{"plain IPv4", plainAddress([4]byte{192, 0, 2, 1}, 32853), nil, "192.0.2.1:32853"},
+		{"XOR IPv4", mustHex("0001a147e112a643"), key, "192.0.2.1:32853"},
+		{"XOR IPv6", mustHex("0002a1470113a9faa5d3f179bc25f4b5bed2b9d9"), key, "[2001:db8:1234:5678:11:2233:4455:6677]:32853"},
+		{"plain IPv6", mustHex("0002138820010db8000000000000000000000001"), nil, "[2001:db8::1]:5000"},
+		{"trailing bytes ignored", append(plainAddress([4]byte{192, 0, 2, 1}, 80), 0xff), nil, "192.0.2.1:80"},
+		{"empty", nil, nil, ""},
+		{"header only", []byte{0, stunFamilyIPv4, 0, 80}, nil, ""},
+		{"truncated IPv4", []byte{0, stunFamilyIPv4, 0, 80, 192, 0, 2}, nil, ""},
+		{"truncated IPv6", mustHex("0002138820010db8"), key, ""},
+		{"unknown family", []byte{0, 3, 0, 80, 192, 0, 2, 1}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := parseAddress(tt.value, tt.key)
+			if tt.addr == "" {
+				if addr != nil {
+					t.Errorf("address = %s, want nil", addr)
+				}
+				return
+			}
+			if addr == nil || addr.String() != tt.addr {
+				t.Errorf("address = %v, want %s", addr, tt.addr)
+			}
+		})
+	}
+}
+
+func FuzzParseBindingResponse(f *testing.F) {
+	f.Add(rfc5769IPv4Response)
+	f.Add(rfc5769IPv6Response)
+	f.Add(stunResponse(stunBindingSuccess, stunAttr(stunAttrMapped, plainAddress([4]byte{203, 0, 113, 5}, 40000))))
+	f.Add(stunResponse(stunBindingSuccess, stunAttr(stunAttrOtherAddr, plainAddress([4]byte{198, 51, 100, 2}, 3479))))
+	f.Add(stunResponse(stunBindingSuccess, stunAttr(stunAttrXORMapped, []byte{0, 2, 0, 1})))
+	f.Add(stunResponse(0x0111))
+	f.Add(rfc5769IPv4Response[:stunHeaderSize])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		mapped, other, _, err := parseBindingResponse(packet, testTransactionID)
+		if err != nil {
+			if mapped != nil || other != nil {
+				t.Fatalf("addresses returned with error %v", err)
+			}
+			return
+		}
+
+		if len(packet) < stunHeaderSize {
+			t.Fatalf("accepted %d-byte packet", len(packet))
+		}
+		if binary.BigEndian.Uint32(packet[4:8]) != stunMagicCookie || string(packet[8:20]) != string(testTransactionID) {
+			t.Fatalf("accepted packet with header % x", packet[:stunHeaderSize])
+		}
+		if binary.BigEndian.Uint16(packet[0:2]) != stunBindingSuccess {
+			t.Fatalf("accepted message type 0x%04x", binary.BigEndian.Uint16(packet[0:2]))
+		}
+		if stunHeaderSize+int(binary.BigEndian.Uint16(packet[2:4])) > len(packet) {
+			t.Fatalf("accepted truncated message")
+		}
+		if mapped == nil {
+			t.Fatalf("success without a mapped address")
+		}
+		if len(mapped.IP) != 4 && len(mapped.IP) != 16 {
+			t.Fatalf("mapped address %s has a %d-byte IP", mapped, len(mapped.IP))
+		}
+		if other != nil && len(other.IP) != 4 && len(other.IP) != 16 {
+			t.Fatalf("other address %s has a %d-byte IP", other, len(other.IP))
+		}
+	})
+}
@@ -0,0 +1,7 @@
+module rtp-bench
+
+go 1.21
+
+require voip v0.0.0
+
+replace voip => ../voip
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"voip/netutil"
+	"voip/pcm"
+	"voip/sip"
+)
+
+const (
+	rtpHeaderSize = 12
+	sampleRate    = 8000
+	toneFrequency = 400.0
+
+	// Every payload starts with a marker that echoes are matched on:
+	// magic, per-call token, packet index and send time in Unix nanoseconds
+	markerMagic = "RTPB"
+	markerSize  = 20
+)
+
+type Config struct {
+	Host        string
+	Port        int
+	Calls       int
+	Duration    time.Duration
+	Ptime       int
+	PayloadType int
+	RampUp      time.Duration
+	MaxLoss     float64
+	Verbose     bool
+
+	// SIP mode
+	Call     string
+	Server   string
+	User     string
+	AuthUser string
+	Password string
+	Domain   string
+	PublicIP string
+	Timeout  int
+	SIPTrace bool
+}
+
+// CallStats holds the measurements collected for a single simulated call
+type CallStats struct {
+	ID         int
+	LocalAddr  string
+	Sent       int
+	Received   int // Our packets echoed back, each counted once
+	Duplicates int
+	Reordered  int
+	Unmatched  int // Packets on the socket that are not echoes of ours
+	RTTs       []time.Duration
+	Jitter     float64 // RFC 3550 interarrival jitter of the echoes, in milliseconds
+	Err        error
+}
+
+func main() {
+	var config Config
+
+	flag.StringVar(&config.Host, "host", "localhost", "Echo endpoint host/IP (without --call)")
+	flag.IntVar(&config.Port, "port", 1505, "Echo endpoint UDP port (without --call)")
+	flag.IntVar(&config.Calls, "calls", 10, "Number of simultaneous calls")
+	flag.DurationVar(&config.Duration, "duration", 10*time.Second, "Duration of each call")
+	flag.IntVar(&config.Ptime, "ptime", 20, "Packetization time in milliseconds")
+	flag.IntVar(&config.PayloadType, "payload-type", 0, "RTP payload type (0 = PCMU)")
+	flag.DurationVar(&config.RampUp, "ramp-up", time.Second, "Time over which calls are started")
+	flag.Float64Var(&config.MaxLoss, "max-loss", 5, "Fail calls whose echo loss exceeds this percentage")
+	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
+	flag.StringVar(&config.Call, "call", "", "Place SIP calls to this URI or user (e.g. sip:echo@localhost or echo) instead of streaming to --host/--port")
+	flag.StringVar(&config.Server, "server", "", "SIP server/outbound proxy (host:port); all requests are sent through it")
+	flag.StringVar(&config.User, "user", "rtp-bench", "SIP user (the user part of our From address)")
+	flag.StringVar(&config.AuthUser, "auth-user", "", "Digest authentication username (defaults to --user)")
+	flag.StringVar(&config.Password, "password", "", "Digest authentication password")
+	flag.StringVar(&config.Domain, "domain", "", "SIP domain (defaults to the --server host)")
+	flag.StringVar(&config.PublicIP, "public-ip", "", "IP address to advertise in Contact/Via and SDP (auto-detected if not specified)")
+	flag.IntVar(&config.Timeout, "timeout", 30, "Seconds to wait for each call to be answered")
+	flag.BoolVar(&config.SIPTrace, "sip-trace", false, "Print every SIP message sent and received")
+	flag.Parse()
+
+	if config.Calls < 1 {
+		log.Fatalf("Invalid number of calls: %d", config.Calls)
+	}
+	if config.Ptime < 10 || config.Ptime > 120 {
+		log.Fatalf("Invalid ptime: %d ms (expected 10-120)", config.Ptime)
+	}
+	if config.MaxLoss < 0 || config.MaxLoss > 100 {
+		log.Fatalf("Invalid --max-loss: %g (expected 0-100)", config.MaxLoss)
+	}
+
+	var serverAddr *net.UDPAddr
+	if config.Call != "" {
+		if config.PayloadType != sip.PayloadTypePCMU {
+			log.Fatalf("SIP calls offer PCMU only; --payload-type must be 0")
+		}
+		if config.AuthUser == "" {
+			config.AuthUser = config.User
+		}
+		if config.Domain == "" && config.Server != "" {
+			host, _, err := net.SplitHostPort(config.Server)
+			if err != nil {
+				host = config.Server
+			}
+			config.Domain = host
+		}
+		if config.PublicIP == "" {
+			ip, err := netutil.DefaultRouteIP()
+			if err != nil {
+				log.Fatalf("Failed to detect local IP, specify --public-ip: %v", err)
+			}
+			config.PublicIP = ip
+		}
+		target, err := sip.NormalizeTarget(config.Call, config.Domain)
+		if err != nil {
+			log.Fatalf("Invalid --call: %v", err)
+		}
+		config.Call = target
+
+		logf("Starting %d SIP calls to %s for %s (ptime %d ms)", config.Calls, config.Call, config.Duration, config.Ptime)
+	} else {
+		var err error
+		serverAddr, err = net.ResolveUDPAddr("udp", net.JoinHostPort(config.Host, fmt.Sprint(config.Port)))
+		if err != nil {
+			log.Fatalf("Failed to resolve echo endpoint: %v", err)
+		}
+
+		logf("Starting %d calls against udp://%s for %s (ptime %d ms)", config.Calls, serverAddr, config.Duration, config.Ptime)
+	}
+
+	// The first signal ends all calls early (hanging up SIP calls), a second one exits immediately
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		logf("Shutdown signal received, ending calls...")
+		close(stop)
+		<-sigChan
+		os.Exit(1)
+	}()
+
+	payload := generateTone(sampleRate * config.Ptime / 1000)
+
+	var results []*CallStats
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < config.Calls; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			stats := runCall(id, serverAddr, payload, config, stop)
+			mu.Lock()
+			results = append(results, stats)
+			mu.Unlock()
+		}(i)
+
+		if config.Calls > 1 && i < config.Calls-1 {
+			select {
+			case <-stop:
+			case <-time.After(config.RampUp / time.Duration(config.Calls-1)):
+				continue
+			}
+			break
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	if !printSummary(results, config.MaxLoss) {
+		os.Exit(1)
+	}
+}
+
+// runCall runs one call: a SIP call with --call, otherwise a bare RTP stream to the echo endpoint
+func runCall(id int, serverAddr *net.UDPAddr, payload []byte, config Config, stop <-chan struct{}) *CallStats {
+	stats := &CallStats{ID: id}
+
+	if config.Call == "" {
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			stats.Err = fmt.Errorf("failed to open socket: %v", err)
+			return stats
+		}
+		defer conn.Close()
+
+		stats.LocalAddr = conn.LocalAddr().String()
+		if config.Verbose {
+			logf("Call %d: streaming from %s", id, stats.LocalAddr)
+		}
+
+		streamRTP(conn, func() *net.UDPAddr { return serverAddr }, nil, payload, config, stats, stop)
+	} else {
+		ua, err := sip.NewUserAgent(sipConfig(config))
+		if err != nil {
+			stats.Err = err
+			return stats
+		}
+		defer ua.Close()
+
+		d, err := ua.Invite(config.Call, stop)
+		if err != nil {
+			stats.Err = fmt.Errorf("call setup failed: %v", err)
+			return stats
+		}
+		conn := d.MediaConn()
+		defer conn.Close()
+
+		stats.LocalAddr = conn.LocalAddr().String()
+		if config.Verbose {
+			logf("Call %d: established (Call-ID %s), streaming from %s to %s", id, d.CallID(), stats.LocalAddr, d.RemoteMedia())
+		}
+
+		streamRTP(conn, d.RemoteMedia, d.Ended(), payload, config, stats, stop)
+
+		select {
+		case <-d.Ended():
+			if stats.Err == nil {
+				stats.Err = fmt.Errorf("%s after %d packets", d.Reason(), stats.Sent)
+			}
+		default:
+			if err := ua.Bye(d); err != nil && stats.Err == nil {
+				stats.Err = fmt.Errorf("hangup failed: %v", err)
+			}
+		}
+	}
+
+	if stats.Err == nil && stats.Sent > 0 {
+		if len(stats.RTTs) == 0 {
+			stats.Err = fmt.Errorf("none of our packets came back (%d other packets received)", stats.Unmatched)
+		} else if lossPercent(stats.Sent, stats.Received) > config.MaxLoss {
+			stats.Err = fmt.Errorf("loss exceeds %g%%", config.MaxLoss)
+		}
+	}
+
+	if config.Verbose {
+		logf("Call %d: sent %d, echoed %d, unmatched %d", id, stats.Sent, stats.Received, stats.Unmatched)
+	}
+
+	return stats
+}
+
+// sipConfig returns the user agent settings for one benchmark call; every call has its own agent and ports
+func sipConfig(config Config) sip.Config {
+	var progress func(string, ...interface{})
+	if config.Verbose || config.SIPTrace {
+		progress = logf
+	}
+	return sip.Config{
+		Name:     "rtp-bench",
+		Server:   config.Server,
+		User:     config.User,
+		AuthUser: config.AuthUser,
+		Password: config.Password,
+		Domain:   config.Domain,
+		PublicIP: config.PublicIP,
+		Ptime:    config.Ptime,
+		Timeout:  config.Timeout,
+		Trace:    config.SIPTrace,
+		Logf:     progress,
+	}
+}
+
+// streamRTP sends a paced RTP stream through conn to remote() and matches what comes back to the
+// same socket against it. Each payload starts with a marker holding a per-call token, the packet
+// index and the send time, so echoes are recognised whatever the far end does to the RTP header,
+// and anything else arriving on the socket (such as the far end's own audio) is not counted.
+func streamRTP(conn *net.UDPConn, remote func() *net.UDPAddr, ended <-chan struct{}, payload []byte, config Config, stats *CallStats, stop <-chan struct{}) {
+	ssrc := rand.Uint32()
+	seq := uint16(rand.Intn(math.MaxUint16))
+	timestamp := rand.Uint32()
+	token := rand.Uint32()
+	samplesPerPacket := uint32(len(payload))
+	packetCount := int(config.Duration / (time.Duration(config.Ptime) * time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		buffer := make([]byte, 1500)
+		matched := make([]bool, packetCount)
+		highest := -1
+		var lastTransit float64
+
+		for {
+			n, _, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			now := time.Now()
+
+			index, sent, ok := parseMarker(buffer[:n], token)
+			if !ok || index >= packetCount {
+				stats.Unmatched++
+				continue
+			}
+			if matched[index] {
+				stats.Duplicates++
+				continue
+			}
+			matched[index] = true
+			stats.Received++
+			if index < highest {
+				stats.Reordered++
+			} else {
+				highest = index
+			}
+
+			transit := now.Sub(sent)
+			stats.RTTs = append(stats.RTTs, transit)
+
+			// RFC 3550 section 6.4.1 with our send time in place of the RTP timestamp: J += (|D(i-1,i)| - J) / 16
+			if len(stats.RTTs) > 1 {
+				stats.Jitter += (math.Abs(float64(transit)/float64(time.Millisecond)-lastTransit) - stats.Jitter) / 16
+			}
+			lastTransit = float64(transit) / float64(time.Millisecond)
+		}
+	}()
+
+	packet := make([]byte, rtpHeaderSize+len(payload))
+	packet[0] = 0x80 // Version 2, no padding, no extension, no CSRC
+	packet[1] = byte(config.PayloadType & 0x7f)
+	binary.BigEndian.PutUint32(packet[8:12], ssrc)
+	copy(packet[rtpHeaderSize:], payload)
+	marker := packet[rtpHeaderSize:]
+	copy(marker[0:4], markerMagic)
+	binary.BigEndian.PutUint32(marker[4:8], token)
+
+	interval := time.Duration(config.Ptime) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+send:
+	for i := 0; i < packetCount; i++ {
+		binary.BigEndian.PutUint16(packet[2:4], seq+uint16(i))
+		binary.BigEndian.PutUint32(packet[4:8], timestamp+uint32(i)*samplesPerPacket)
+		binary.BigEndian.PutUint32(marker[8:12], uint32(i))
+		binary.BigEndian.PutUint64(marker[12:20], uint64(time.Now().UnixNano()))
+
+		if _, err := conn.WriteToUDP(packet, remote()); err != nil {
+			stats.Err = fmt.Errorf("failed to send packet %d: %v", i, err)
+			break
+		}
+		stats.Sent++
+
+		select {
+		case <-ticker.C:
+		case <-ended:
+			break send
+		case <-stop:
+			break send
+		}
+	}
+
+	// Give in-flight echoes a chance to arrive before closing the socket
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	<-done
+}
+
+// parseMarker returns the packet index and send time of one of our packets echoed back, or false
+// for anything else: other RTP streams, RTCP, or packets from another call
+func parseMarker(packet []byte, token uint32) (int, time.Time, bool) {
+	if len(packet) < rtpHeaderSize || packet[0]>>6 != 2 {
+		return 0, time.Time{}, false
+	}
+
+	headerSize := rtpHeaderSize + int(packet[0]&0x0f)*4
+	if packet[0]&0x10 != 0 && len(packet) >= headerSize+4 {
+		headerSize += 4 + int(binary.BigEndian.Uint16(packet[headerSize+2:headerSize+4]))*4
+	}
+	end := len(packet)
+	if packet[0]&0x20 != 0 && end > headerSize {
+		end -= int(packet[end-1])
+	}
+	if end-headerSize < markerSize {
+		return 0, time.Time{}, false
+	}
+
+	marker := packet[headerSize:end]
+	if string(marker[0:4]) != markerMagic || binary.BigEndian.Uint32(marker[4:8]) != token {
+		return 0, time.Time{}, false
+	}
+	index := int(binary.BigEndian.Uint32(marker[8:12]))
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(marker[12:20])))
+	return index, sent, true
+}
+
+// printSummary prints per-call and aggregate results, returning false if any call failed
+func printSummary(results []*CallStats, maxLoss float64) bool {
+	var allRTTs []time.Duration
+	var totalSent, totalReceived, totalDuplicates, totalReordered, totalUnmatched, failed, measured int
+	var jitterSum float64
+
+	fmt.Println()
+	fmt.Printf("%-5s %-22s %7s %7s %7s %9s %9s %9s\n", "CALL", "LOCAL", "SENT", "RECV", "LOSS%", "RTT p50", "RTT p95", "JITTER")
+	for _, stats := range results {
+		if stats.Err != nil {
+			failed++
+		}
+		if stats.Sent == 0 {
+			fmt.Printf("%-5d %-22s error: %v\n", stats.ID, stats.LocalAddr, stats.Err)
+			continue
+		}
+
+		sortDurations(stats.RTTs)
+		jitter := "n/a"
+		if len(stats.RTTs) > 1 {
+			jitter = fmt.Sprintf("%.2fms", stats.Jitter)
+			jitterSum += stats.Jitter
+			measured++
+		}
+		status := ""
+		if stats.Err != nil {
+			status = fmt.Sprintf("  FAILED: %v", stats.Err)
+		}
+		fmt.Printf("%-5d %-22s %7d %7d %6.2f%% %9s %9s %9s%s\n",
+			stats.ID, stats.LocalAddr, stats.Sent, stats.Received, lossPercent(stats.Sent, stats.Received),
+			formatPercentile(stats.RTTs, 50), formatPercentile(stats.RTTs, 95), jitter, status)
+
+		totalSent += stats.Sent
+		totalReceived += stats.Received
+		totalDuplicates += stats.Duplicates
+		totalReordered += stats.Reordered
+		totalUnmatched += stats.Unmatched
+		allRTTs = append(allRTTs, stats.RTTs...)
+	}
+
+	sortDurations(allRTTs)
+
+	fmt.Println()
+	fmt.Printf("Calls:      %d ok, %d failed (max loss %g%%)\n", len(results)-failed, failed, maxLoss)
+	fmt.Printf("Packets:    %d sent, %d echoed, %d duplicates, %d reordered, %d unmatched\n",
+		totalSent, totalReceived, totalDuplicates, totalReordered, totalUnmatched)
+	fmt.Printf("Loss:       %.2f%%\n", lossPercent(totalSent, totalReceived))
+	if len(allRTTs) == 0 {
+		fmt.Printf("RTT:        n/a (no echoes matched)\n")
+	} else {
+		fmt.Printf("RTT:        p50 %s, p95 %s, p99 %s, max %s\n",
+			formatPercentile(allRTTs, 50), formatPercentile(allRTTs, 95), formatPercentile(allRTTs, 99), formatPercentile(allRTTs, 100))
+	}
+	if measured > 0 {
+		fmt.Printf("Jitter:     %.2fms average\n", jitterSum/float64(measured))
+	} else {
+		fmt.Printf("Jitter:     n/a\n")
+	}
+
+	return failed == 0
+}
+
+// generateTone returns one packet worth of a mu-law encoded sine wave
+func generateTone(samples int) []byte {
+	payload := make([]byte, samples)
+	for i := range payload {
+		sample := math.Sin(2 * math.Pi * toneFrequency * float64(i) / sampleRate)
+		payload[i] = pcm.LinearToULaw(int16(sample * 8000))
+	}
+	return payload
+}
+
+// lossPercent returns the share of sent packets that were not echoed back
+func lossPercent(sent, received int) float64 {
+	if sent == 0 {
+		return 0
+	}
+	return float64(sent-received) * 100 / float64(sent)
+}
+
+func sortDurations(durations []time.Duration) {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+}
+
+// formatPercentile returns the p-th percentile of an already sorted slice, or n/a if it is empty
+func formatPercentile(sorted []time.Duration, p int) string {
+	if len(sorted) == 0 {
+		return "n/a"
+	}
+	index := (len(sorted)*p+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+	return fmt.Sprintf("%.2fms", float64(sorted[index])/float64(time.Millisecond))
+}
+
+// logf prints a timestamped log message
+func logf(format string, args ...interface{}) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+	fmt.Printf("[%s] %s\n", timestamp, fmt.Sprintf(format, args...))
+}
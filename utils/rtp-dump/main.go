@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"voip/pcm"
+)
+
+const (
+	rtpHeaderSize = 12
+	sampleRate    = 8000
+
+	// Audio is placed by RTP timestamp; jumps beyond this are ignored rather than padded with silence
+	maxTimestampJump = 10 * sampleRate
+)
+
+type Config struct {
+	Port        int
+	Output      string
+	Duration    time.Duration
+	IdleTimeout time.Duration
+	Verbose     bool
+}
+
+// streamStats counts what arrived on the recorded stream
+type streamStats struct {
+	received   int
+	lost       int
+	reordered  int
+	duplicates int
+	ignored    int
+}
+
+func main() {
+	var config Config
+
+	flag.IntVar(&config.Port, "port", 0, "UDP port to receive RTP on")
+	flag.StringVar(&config.Output, "output", "", "File to write: .wav for PCMU/PCMA, .ogg/.opus for Opus")
+	flag.DurationVar(&config.Duration, "duration", 0, "Stop after recording this long (0 = until idle or interrupted)")
+	flag.DurationVar(&config.IdleTimeout, "idle-timeout", 5*time.Second, "Stop when no RTP arrives for this long once the stream has started (0 = never)")
+	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
+	flag.Parse()
+
+	if config.Port == 0 || config.Output == "" {
+		log.Fatalf("--port and --output are required")
+	}
+
+	ext := strings.ToLower(filepath.Ext(config.Output))
+	if ext != ".wav" && ext != ".ogg" && ext != ".opus" {
+		log.Fatalf("Unsupported output format %q: use .wav or .ogg", ext)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.Port})
+	if err != nil {
+		log.Fatalf("Failed to listen on UDP port %d: %v", config.Port, err)
+	}
+	defer conn.Close()
+
+	logf("Waiting for RTP on %s, writing to %s", conn.LocalAddr(), config.Output)
+
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		close(stop)
+	}()
+
+	d := &dumper{config: config, wantOpus: ext != ".wav"}
+	reason := d.run(conn, stop)
+
+	if d.stats.received == 0 {
+		logf("Stopped (%s) without receiving RTP", reason)
+		os.Exit(1)
+	}
+
+	s := d.stats
+	logf("Stopped: %s", reason)
+	logf("Stream: SSRC 0x%08x from %s, payload type %d (%s)", d.ssrc, d.source, d.payloadType, d.codec)
+	logf("Packets: received %d, lost %d, reordered %d, duplicates %d, other streams ignored %d",
+		s.received, s.lost, s.reordered, s.duplicates, s.ignored)
+
+	if d.wantOpus {
+		if err := writeOggOpus(config.Output, d.packets); err != nil {
+			log.Fatalf("Failed to write %s: %v", config.Output, err)
+		}
+		logf("Wrote %d Opus packets to %s", len(d.packets), config.Output)
+	} else {
+		if err := pcm.WriteWAV(config.Output, d.samples, sampleRate); err != nil {
+			log.Fatalf("Failed to write %s: %v", config.Output, err)
+		}
+		logf("Wrote %.1fs of audio to %s", float64(len(d.samples))/sampleRate, config.Output)
+	}
+}
+
+// dumper records a single RTP stream, locking onto the first SSRC it sees
+type dumper struct {
+	config   Config
+	wantOpus bool
+
+	started        bool
+	source         *net.UDPAddr
+	ssrc           uint32
+	payloadType    uint8
+	codec          string
+	firstTimestamp uint32
+	highestSeq     int64 // Extended sequence number, so wraparound does not look like reordering
+	seen           map[int64]bool
+
+	stats   streamStats
+	samples []int16
+	packets []opusPacket
+}
+
+// run receives packets until interrupted, idle or --duration is reached and returns why it stopped
+func (d *dumper) run(conn *net.UDPConn, stop <-chan struct{}) string {
+	buffer := make([]byte, 1500)
+	var firstPacket, lastPacket time.Time
+	var lastDrop string
+
+	for {
+		select {
+		case <-stop:
+			return "interrupted"
+		default:
+		}
+
+		now := time.Now()
+		if d.started && d.config.IdleTimeout > 0 && now.Sub(lastPacket) >= d.config.IdleTimeout {
+			return fmt.Sprintf("no RTP for %s", d.config.IdleTimeout)
+		}
+		if d.started && d.config.Duration > 0 && now.Sub(firstPacket) >= d.config.Duration {
+			return "duration reached"
+		}
+
+		// Poll so that signals and timeouts are noticed while the stream is quiet
+		conn.SetReadDeadline(now.Add(100 * time.Millisecond))
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			log.Printf("Read error: %v", err)
+			return "read error"
+		}
+
+		if err := d.handle(buffer[:n], from); err != nil {
+			// Until the stream starts, say why nothing is being recorded (once per reason)
+			if d.config.Verbose || (!d.started && err.Error() != lastDrop) {
+				logf("Dropping packet from %s: %v", from, err)
+			}
+			lastDrop = err.Error()
+			continue
+		}
+
+		lastPacket = time.Now()
+		if firstPacket.IsZero() {
+			firstPacket = lastPacket
+		}
+	}
+}
+
+// handle processes one datagram; packets not belonging to the recorded stream are counted and dropped
+func (d *dumper) handle(packet []byte, from *net.UDPAddr) error {
+	if len(packet) < rtpHeaderSize || packet[0]>>6 != 2 {
+		return fmt.Errorf("not RTP")
+	}
+
+	payloadType := packet[1] & 0x7f
+	if payloadType >= 72 && payloadType <= 76 {
+		return fmt.Errorf("RTCP")
+	}
+
+	headerSize := rtpHeaderSize + int(packet[0]&0x0f)*4
+	if packet[0]&0x10 != 0 && len(packet) >= headerSize+4 {
+		headerSize += 4 + int(binary.BigEndian.Uint16(packet[headerSize+2:headerSize+4]))*4
+	}
+	end := len(packet)
+	if packet[0]&0x20 != 0 && end > headerSize {
+		end -= int(packet[end-1])
+	}
+	if headerSize > end {
+		return fmt.Errorf("truncated packet")
+	}
+	payload := packet[headerSize:end]
+
+	seq := binary.BigEndian.Uint16(packet[2:4])
+	timestamp := binary.BigEndian.Uint32(packet[4:8])
+	ssrc := binary.BigEndian.Uint32(packet[8:12])
+
+	if !d.started {
+		codec, err := codecFor(payloadType, d.wantOpus)
+		if err != nil {
+			return err
+		}
+		d.started = true
+		d.source = from
+		d.ssrc = ssrc
+		d.payloadType = payloadType
+		d.codec = codec
+		d.firstTimestamp = timestamp
+		d.highestSeq = int64(seq)
+		d.seen = make(map[int64]bool)
+		logf("Recording %s stream SSRC 0x%08x from %s (payload type %d)", codec, ssrc, from, payloadType)
+	} else if ssrc != d.ssrc {
+		d.stats.ignored++
+		return fmt.Errorf("other stream SSRC 0x%08x", ssrc)
+	} else if payloadType != d.payloadType {
+		return fmt.Errorf("payload type changed to %d", payloadType)
+	}
+
+	// Extend the 16-bit sequence number relative to the highest one seen
+	extended := d.highestSeq + int64(int16(seq-uint16(d.highestSeq)))
+	if d.seen[extended] {
+		d.stats.duplicates++
+		return nil
+	}
+	d.seen[extended] = true
+	d.stats.received++
+
+	switch {
+	case extended > d.highestSeq:
+		d.stats.lost += int(extended - d.highestSeq - 1)
+		d.highestSeq = extended
+	case extended < d.highestSeq:
+		// A late packet fills a gap that was counted as lost
+		d.stats.reordered++
+		if d.stats.lost > 0 {
+			d.stats.lost--
+		}
+	}
+
+	// Forget old sequence numbers so long recordings do not grow the map without bound
+	if len(d.seen) > 4096 {
+		for s := range d.seen {
+			if s < d.highestSeq-1024 {
+				delete(d.seen, s)
+			}
+		}
+	}
+
+	offset := int(int32(timestamp - d.firstTimestamp))
+	if d.codec == "opus" {
+		if offset < 0 {
+			return fmt.Errorf("packet older than the start of the recording")
+		}
+		d.packets = append(d.packets, opusPacket{seq: extended, offset: int64(offset), payload: append([]byte(nil), payload...)})
+		return nil
+	}
+
+	if offset < 0 || offset-len(d.samples) > maxTimestampJump {
+		return fmt.Errorf("timestamp jump of %d samples", offset-len(d.samples))
+	}
+	if end := offset + len(payload); end > len(d.samples) {
+		d.samples = append(d.samples, make([]int16, end-len(d.samples))...)
+	}
+	for i, b := range payload {
+		d.samples[offset+i] = pcm.DecodeG711(b, d.codec)
+	}
+	return nil
+}
+
+// codecFor maps a payload type to a codec this output format can hold; dynamic types are assumed to be Opus
+func codecFor(payloadType uint8, wantOpus bool) (string, error) {
+	var codec string
+	switch {
+	case payloadType == 0:
+		codec = "pcmu"
+	case payloadType == 8:
+		codec = "pcma"
+	case payloadType >= 96 && payloadType <= 127:
+		codec = "opus"
+	default:
+		return "", fmt.Errorf("unsupported payload type %d", payloadType)
+	}
+
+	if wantOpus != (codec == "opus") {
+		if wantOpus {
+			return "", fmt.Errorf("%s stream cannot be written to Ogg; use a .wav output", codec)
+		}
+		return "", fmt.Errorf("opus stream cannot be written to WAV; use an .ogg output")
+	}
+	return codec, nil
+}
+
+// logf prints a timestamped log message
+func logf(format string, args ...interface{}) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+	fmt.Printf("[%s] %s\n", timestamp, fmt.Sprintf(format, args...))
+}
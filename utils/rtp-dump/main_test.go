@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+var testSource = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+
+// rtpPacket builds an RTP packet with the given first byte, payload type, sequence number, timestamp and SSRC
+func rtpPacket(first, payloadType byte, seq uint16, timestamp, ssrc uint32, rest ...byte) []byte {
+	packet := make([]byte, rtpHeaderSize, rtpHeaderSize+len(rest))
+	packet[0] = first
+	packet[1] = payloadType
+	binary.BigEndian.PutUint16(packet[2:4], seq)
+	binary.BigEndian.PutUint32(packet[4:8], timestamp)
+	binary.BigEndian.PutUint32(packet[8:12], ssrc)
+	return append(packet, rest...)
+}
+
+func TestHandleSequence(t *testing.T) {
+	tests := []struct {
+		name    string
+		seqs    []uint16
+		highest int64
+		stats   streamStats
+	}{
+		{name: "in order", seqs: []uint16{10, 11, 12}, highest: 12, stats: streamStats{received: 3}},
+		{name: "wraparound", seqs: []uint16{65534, 65535, 0, 1}, highest: 65537, stats: streamStats{received: 4}},
+		{name: "gap", seqs: []uint16{10, 13}, highest: 13, stats: streamStats{received: 2, lost: 2}},
+		{name: "late packet fills the gap", seqs: []uint16{10, 12, 11}, highest: 12, stats: streamStats{received: 3, reordered: 1}},
+		{name: "late packet across wraparound", seqs: []uint16{65534, 0, 65535}, highest: 65536, stats: streamStats{received: 3, reordered: 1}},
+		{name: "duplicate", seqs: []uint16{10, 11, 11}, highest: 11, stats: streamStats{received: 2, duplicates: 1}},
+		{name: "late duplicate", seqs: []uint16{10, 12, 11, 11}, highest: 12, stats: streamStats{received: 3, reordered: 1, duplicates: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dumper{}
+			for i, seq := range tt.seqs {
+				// Timestamps follow the sequence numbers so every packet lands in the recording
+				timestamp := uint32(1000 + int(seq-tt.seqs[0])*160)
+				if err := d.handle(rtpPacket(0x80, 0, seq, timestamp, 0x1234, 0xff), testSource); err != nil {
+					t.Fatalf("packet %d (seq %d): %v", i, seq, err)
+				}
+			}
+			if d.highestSeq != tt.highest {
+				t.Errorf("highest sequence = %d, want %d", d.highestSeq, tt.highest)
+			}
+			if d.stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", d.stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestHandleForgetsOldSequenceNumbers(t *testing.T) {
+	d := &dumper{}
+	for i := 0; i < 10000; i++ {
+		if err := d.handle(rtpPacket(0x80, 0, uint16(i), uint32(i), 1), testSource); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+	}
+	if len(d.seen) > 4096 {
+		t.Errorf("%d sequence numbers remembered", len(d.seen))
+	}
+	if d.stats.received != 10000 || d.stats.lost != 0 || d.stats.duplicates != 0 {
+		t.Errorf("stats = %+v", d.stats)
+	}
+}
+
+func TestHandleG711Placement(t *testing.T) {
+	d := &dumper{}
+	payload := func(b byte) []byte { return []byte(strings.Repeat(string([]byte{b}), 160)) }
+
+	// mu-law 0x80 and 0x00 decode to the extremes, so every placed sample is non-zero
+	steps := []struct {
+		seq       uint16
+		timestamp uint32
+		payload   []byte
+	}{
+		{100, 8000, payload(0x80)},
+		{102, 8320, payload(0x00)}, // 20 ms lost before it
+		{101, 8160, payload(0x80)}, // the late packet fills the gap
+	}
+	for _, s := range steps {
+		if err := d.handle(rtpPacket(0x80, 0, s.seq, s.timestamp, 1, s.payload...), testSource); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(d.samples) != 480 {
+		t.Fatalf("%d samples, want 480", len(d.samples))
+	}
+	for i, sample := range d.samples {
+		want := int16(32124)
+		if i >= 320 {
+			want = -32124
+		}
+		if sample != want {
+			t.Fatalf("sample %d = %d, want %d", i, sample, want)
+		}
+	}
+
+	// A gap stays silent until something fills it
+	d = &dumper{}
+	d.handle(rtpPacket(0x80, 8, 1, 0, 1, 0xd5, 0xd5), testSource)
+	d.handle(rtpPacket(0x80, 8, 3, 4, 1, 0xd5, 0xd5), testSource)
+	want := []int16{8, 8, 0, 0, 8, 8}
+	if len(d.samples) != len(want) {
+		t.Fatalf("samples = %v, want %v", d.samples, want)
+	}
+	for i := range want {
+		if d.samples[i] != want[i] {
+			t.Fatalf("samples = %v, want %v", d.samples, want)
+		}
+	}
+}
+
+func TestHandleStrips(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		samples int
+	}{
+		{"CSRC", rtpPacket(0x81, 0, 1, 0, 1, 0, 0, 0, 9, 0xff, 0xff), 2},
+		{"header extension", rtpPacket(0x90, 0, 1, 0, 1, 0xbe, 0xde, 0, 1, 0x10, 0xaa, 0, 0, 0xff, 0xff, 0xff), 3},
+		{"padding", rtpPacket(0xa0, 0, 1, 0, 1, 0xff, 0, 0, 3), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dumper{}
+			if err := d.handle(tt.packet, testSource); err != nil {
+				t.Fatal(err)
+			}
+			if len(d.samples) != tt.samples {
+				t.Errorf("%d samples, want %d", len(d.samples), tt.samples)
+			}
+		})
+	}
+}
+
+func TestHandleDrops(t *testing.T) {
+	started := func() *dumper {
+		d := &dumper{}
+		if err := d.handle(rtpPacket(0x80, 0, 1, 1000, 0x1234, 0xff), testSource); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name    string
+		dumper  *dumper
+		packet  []byte
+		err     string
+		ignored int
+	}{
+		{"too short", &dumper{}, []byte{0x80, 0, 0, 1}, "not RTP", 0},
+		{"version 1", &dumper{}, rtpPacket(0x40, 0, 1, 0, 1), "not RTP", 0},
+		{"RTCP", &dumper{}, rtpPacket(0x80, 200, 1, 0, 1), "RTCP", 0},
+		{"truncated CSRC list", &dumper{}, rtpPacket(0x82, 0, 1, 0, 1, 0, 0, 0, 1), "truncated packet", 0},
+		{"padding longer than the packet", &dumper{}, rtpPacket(0xa0, 0, 1, 0, 1, 0xff, 200), "truncated packet", 0},
+		{"static payload type that is not G.711", &dumper{}, rtpPacket(0x80, 18, 1, 0, 1, 0xff), "unsupported payload type 18", 0},
+		{"other SSRC", started(), rtpPacket(0x80, 0, 2, 1160, 0x5678, 0xff), "other stream", 1},
+		{"payload type change", started(), rtpPacket(0x80, 8, 2, 1160, 0x1234, 0xd5), "payload type changed", 0},
+		{"timestamp jump", started(), rtpPacket(0x80, 0, 2, 1000+maxTimestampJump+10, 0x1234, 0xff), "timestamp jump", 0},
+		{"timestamp before the start", started(), rtpPacket(0x80, 0, 2, 500, 0x1234, 0xff), "timestamp jump", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.dumper.handle(tt.packet, testSource)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+			if tt.dumper.stats.ignored != tt.ignored {
+				t.Errorf("ignored = %d, want %d", tt.dumper.stats.ignored, tt.ignored)
+			}
+		})
+	}
+}
+
+func TestHandleOpus(t *testing.T) {
+	d := &dumper{wantOpus: true}
+	d.handle(rtpPacket(0x80, 111, 7, 48000, 1, 0x78, 1), testSource)
+	d.handle(rtpPacket(0x80, 111, 9, 48000+1920, 1, 0x78, 3), testSource)
+	d.handle(rtpPacket(0x80, 111, 8, 48000+960, 1, 0x78, 2), testSource)
+
+	if d.codec != "opus" || len(d.packets) != 3 {
+		t.Fatalf("codec %q with %d packets", d.codec, len(d.packets))
+	}
+	for i, want := range []opusPacket{{7, 0, []byte{0x78, 1}}, {9, 1920, []byte{0x78, 3}}, {8, 960, []byte{0x78, 2}}} {
+		p := d.packets[i]
+		if p.seq != want.seq || p.offset != want.offset || string(p.payload) != string(want.payload) {
+			t.Errorf("packet %d = %+v, want %+v", i, p, want)
+		}
+	}
+
+	if err := d.handle(rtpPacket(0x80, 111, 6, 48000-960, 1, 0x78), testSource); err == nil {
+		t.Errorf("packet from before the recording started accepted")
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		payloadType uint8
+		wantOpus    bool
+		codec       string
+		err         string
+	}{
+		{0, false, "pcmu", ""},
+		{8, false, "pcma", ""},
+		{96, true, "opus", ""},
+		{111, true, "opus", ""},
+		{127, true, "opus", ""},
+		{0, true, "", "pcmu stream cannot be written to Ogg"},
+		{8, true, "", "pcma stream cannot be written to Ogg"},
+		{111, false, "", "opus stream cannot be written to WAV"},
+		{3, false, "", "unsupported payload type 3"},
+		{18, true, "", "unsupported payload type 18"},
+		{95, true, "", "unsupported payload type 95"},
+	}
+
+	for _, tt := range tests {
+		codec, err := codecFor(tt.payloadType, tt.wantOpus)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("codecFor(%d, %v) error = %v, want %q", tt.payloadType, tt.wantOpus, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || codec != tt.codec {
+			t.Errorf("codecFor(%d, %v) = %q, %v, want %q", tt.payloadType, tt.wantOpus, codec, err, tt.codec)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// Opus RTP always uses a 48 kHz clock, whatever the encoder's internal rate (RFC 7587)
+const opusClockRate = 48000
+
+// opusPacket is a received Opus payload and its RTP timestamp relative to the first packet
+type opusPacket struct {
+	seq     int64
+	offset  int64
+	payload []byte
+}
+
+var oggCRCTable = func() [256]uint32 {
+	// Ogg uses CRC-32 with polynomial 0x04c11db7, unreflected, with zero initial value
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// oggWriter writes single-packet pages of one logical Ogg stream
+type oggWriter struct {
+	buf      bytes.Buffer
+	serial   uint32
+	sequence uint32
+}
+
+// writePage appends a page holding one complete packet
+func (w *oggWriter) writePage(packet []byte, granule int64, headerType byte) {
+	// Lacing: 255-byte segments, terminated by one shorter (possibly empty) segment
+	lacing := bytes.Repeat([]byte{255}, len(packet)/255)
+	lacing = append(lacing, byte(len(packet)%255))
+
+	page := make([]byte, 27, 27+len(lacing)+len(packet))
+	copy(page[0:4], "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:18], w.serial)
+	binary.LittleEndian.PutUint32(page[18:22], w.sequence)
+	page[26] = byte(len(lacing))
+	page = append(page, lacing...)
+	page = append(page, packet...)
+
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:26], crc)
+
+	w.buf.Write(page)
+	w.sequence++
+}
+
+// writeOggOpus writes received Opus packets as an Ogg Opus file (RFC 7845), in sequence number order
+func writeOggOpus(path string, packets []opusPacket) error {
+	if len(packets) == 0 {
+		return fmt.Errorf("no Opus packets")
+	}
+
+	sort.Slice(packets, func(i, j int) bool { return packets[i].seq < packets[j].seq })
+
+	// The stereo flag of the first packet's TOC byte decides the channel count
+	channels := byte(1)
+	if len(packets[0].payload) > 0 && packets[0].payload[0]&0x04 != 0 {
+		channels = 2
+	}
+
+	head := make([]byte, 19)
+	copy(head[0:8], "OpusHead")
+	head[8] = 1 // Version
+	head[9] = channels
+	// Pre-skip and output gain stay zero: the stream started mid-flow, there is no encoder delay to trim
+	binary.LittleEndian.PutUint32(head[12:16], opusClockRate)
+
+	vendor := "rtp-dump"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags[0:8], "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:12], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+
+	w := &oggWriter{serial: rand.Uint32()}
+	w.writePage(head, 0, 0x02) // Beginning of stream
+	w.writePage(tags, 0, 0)
+
+	for i, p := range packets {
+		// Granule position is the end of the packet's audio, so timestamp gaps from
+		// lost packets are preserved rather than collapsed
+		granule := p.offset
+		if duration, err := opusPacketDuration(p.payload); err == nil {
+			granule += int64(duration * opusClockRate / time.Second)
+		} else if i+1 < len(packets) {
+			granule = packets[i+1].offset
+		}
+
+		var headerType byte
+		if i == len(packets)-1 {
+			headerType = 0x04 // End of stream
+		}
+		w.writePage(p.payload, granule, headerType)
+	}
+
+	return os.WriteFile(path, w.buf.Bytes(), 0644)
+}
+
+// opusPacketDuration returns the audio duration of an Opus packet from its TOC byte (RFC 6716 section 3.1)
+func opusPacketDuration(packet []byte) (time.Duration, error) {
+	if len(packet) < 1 {
+		return 0, fmt.Errorf("empty Opus packet")
+	}
+
+	toc := packet[0]
+	config := int(toc >> 3)
+
+	var frameDuration time.Duration
+	switch {
+	case config < 12: // SILK-only: 10, 20, 40, 60 ms
+		frameDuration = []time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // Hybrid: 10, 20 ms
+		frameDuration = []time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT-only: 2.5, 5, 10, 20 ms
+		frameDuration = []time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	var frameCount int
+	switch toc & 0x03 {
+	case 0:
+		frameCount = 1
+	case 1, 2:
+		frameCount = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0, fmt.Errorf("truncated Opus packet")
+		}
+		frameCount = int(packet[1] & 0x3f)
+	}
+
+	duration := time.Duration(frameCount) * frameDuration
+	if duration == 0 || duration > 120*time.Millisecond {
+		return 0, fmt.Errorf("invalid Opus packet duration %s", duration)
+	}
+	return duration, nil
+}
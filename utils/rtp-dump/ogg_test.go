@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// oggPage is a parsed Ogg page
+type oggPage struct {
+	headerType byte
+	granule    int64
+	serial     uint32
+	sequence   uint32
+	lacing     []byte
+	packet     []byte
+}
+
+// oggCRC computes the Ogg page checksum bit by bit, independently of the lookup table
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// readOggPages splits a file into pages, checking the capture pattern, version and checksum of each
+func readOggPages(t *testing.T, data []byte) []oggPage {
+	t.Helper()
+	var pages []oggPage
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[0:4]) != "OggS" || data[4] != 0 {
+			t.Fatalf("page %d: bad header % x", len(pages), data[:min(len(data), 27)])
+		}
+		segments := int(data[26])
+		lacing := data[27 : 27+segments]
+		size := 0
+		for _, l := range lacing {
+			size += int(l)
+		}
+		end := 27 + segments + size
+		if end > len(data) {
+			t.Fatalf("page %d: %d bytes of packet data, %d left", len(pages), size, len(data)-27-segments)
+		}
+
+		page := append([]byte(nil), data[:end]...)
+		want := binary.LittleEndian.Uint32(page[22:26])
+		binary.LittleEndian.PutUint32(page[22:26], 0)
+		if got := oggCRC(page); got != want {
+			t.Fatalf("page %d: CRC %08x, computed %08x", len(pages), want, got)
+		}
+
+		pages = append(pages, oggPage{
+			headerType: data[5],
+			granule:    int64(binary.LittleEndian.Uint64(data[6:14])),
+			serial:     binary.LittleEndian.Uint32(data[14:18]),
+			sequence:   binary.LittleEndian.Uint32(data[18:22]),
+			lacing:     lacing,
+			packet:     data[27+segments : end],
+		})
+		data = data[end:]
+	}
+	return pages
+}
+
+func TestOggCRCTable(t *testing.T) {
+	// CRC-32 with polynomial 0x04c11db7, no reflection, zero initial value and no final XOR
+	var crc uint32
+	for _, b := range []byte("123456789") {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	if crc != 0x89a1897f {
+		t.Errorf("CRC of 123456789 = %08x, want 89a1897f", crc)
+	}
+}
+
+func TestOggLacing(t *testing.T) {
+	tests := []struct {
+		size   int
+		lacing []byte
+	}{
+		{0, []byte{0}},
+		{1, []byte{1}},
+		{254, []byte{254}},
+		{255, []byte{255, 0}},
+		{256, []byte{255, 1}},
+		{510, []byte{255, 255, 0}},
+		{600, []byte{255, 255, 90}},
+	}
+
+	for _, tt := range tests {
+		w := &oggWriter{serial: 0x01020304, sequence: 5}
+		packet := bytes.Repeat([]byte{0xab}, tt.size)
+		w.writePage(packet, 12345, 0x04)
+
+		pages := readOggPages(t, w.buf.Bytes())
+		if len(pages) != 1 {
+			t.Fatalf("%d bytes: %d pages", tt.size, len(pages))
+		}
+		p := pages[0]
+		if !bytes.Equal(p.lacing, tt.lacing) {
+			t.Errorf("%d bytes: lacing % x, want % x", tt.size, p.lacing, tt.lacing)
+		}
+		if !bytes.Equal(p.packet, packet) {
+			t.Errorf("%d bytes: packet data changed", tt.size)
+		}
+		if p.headerType != 0x04 || p.granule != 12345 || p.serial != 0x01020304 || p.sequence != 5 {
+			t.Errorf("%d bytes: header %+v", tt.size, p)
+		}
+		if w.sequence != 6 {
+			t.Errorf("%d bytes: next sequence %d, want 6", tt.size, w.sequence)
+		}
+	}
+}
+
+func TestWriteOggOpus(t *testing.T) {
+	// TOC 0x78 is hybrid fullband 20 ms, one frame; 0x7c is the same in stereo
+	packets := []opusPacket{
+		{seq: 12, offset: 2880, payload: []byte{0x78, 3}},
+		{seq: 10, offset: 0, payload: []byte{0x7c, 1}}, // arrives late, still written first
+		{seq: 11, offset: 960, payload: []byte{0x7b}},  // code 3 without a frame count byte
+	}
+	path := filepath.Join(t.TempDir(), "out.opus")
+	if err := writeOggOpus(path, packets); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := readOggPages(t, data)
+	if len(pages) != 5 {
+		t.Fatalf("%d pages, want OpusHead, OpusTags and 3 audio pages", len(pages))
+	}
+	for i, p := range pages {
+		if p.serial != pages[0].serial || p.sequence != uint32(i) {
+			t.Errorf("page %d: serial %08x sequence %d", i, p.serial, p.sequence)
+		}
+	}
+
+	head := pages[0]
+	if head.headerType != 0x02 || len(head.packet) != 19 || string(head.packet[0:8]) != "OpusHead" {
+		t.Fatalf("first page is not a beginning-of-stream OpusHead: %+v", head)
+	}
+	if head.packet[8] != 1 || head.packet[9] != 2 || binary.LittleEndian.Uint32(head.packet[12:16]) != opusClockRate {
+		t.Errorf("OpusHead version %d, %d channels, %d Hz", head.packet[8], head.packet[9], binary.LittleEndian.Uint32(head.packet[12:16]))
+	}
+	if string(pages[1].packet[0:8]) != "OpusTags" || pages[1].granule != 0 {
+		t.Errorf("second page is not OpusTags: %+v", pages[1])
+	}
+
+	want := []struct {
+		payload    []byte
+		granule    int64
+		headerType byte
+	}{
+		{[]byte{0x7c, 1}, 960, 0},
+		{[]byte{0x7b}, 2880, 0}, // unknown duration: ends where the next packet starts
+		{[]byte{0x78, 3}, 3840, 0x04},
+	}
+	for i, w := range want {
+		p := pages[2+i]
+		if !bytes.Equal(p.packet, w.payload) || p.granule != w.granule || p.headerType != w.headerType {
+			t.Errorf("audio page %d = % x granule %d type %d, want % x granule %d type %d",
+				i, p.packet, p.granule, p.headerType, w.payload, w.granule, w.headerType)
+		}
+	}
+
+	if err := writeOggOpus(path, nil); err == nil {
+		t.Errorf("empty recording written")
+	}
+}
+
+func TestOpusPacketDuration(t *testing.T) {
+	tests := []struct {
+		packet   []byte
+		duration time.Duration
+		err      bool
+	}{
+		// SILK-only configs 0-11 cycle through 10, 20, 40, 60 ms
+		{[]byte{0 << 3}, 10 * time.Millisecond, false},
+		{[]byte{1 << 3}, 20 * time.Millisecond, false},
+		{[]byte{6 << 3}, 40 * time.Millisecond, false},
+		{[]byte{11 << 3}, 60 * time.Millisecond, false},
+		// Hybrid configs 12-15 alternate 10 and 20 ms
+		{[]byte{12 << 3}, 10 * time.Millisecond, false},
+		{[]byte{15 << 3}, 20 * time.Millisecond, false},
+		// CELT-only configs 16-31 cycle through 2.5, 5, 10, 20 ms
+		{[]byte{16 << 3}, 2500 * time.Microsecond, false},
+		{[]byte{21 << 3}, 5 * time.Millisecond, false},
+		{[]byte{30 << 3}, 10 * time.Millisecond, false},
+		{[]byte{31 << 3}, 20 * time.Millisecond, false},
+		// The stereo flag does not change the duration
+		{[]byte{31<<3 | 0x04}, 20 * time.Millisecond, false},
+		// Codes 1 and 2 carry two frames
+		{[]byte{1<<3 | 1}, 40 * time.Millisecond, false},
+		{[]byte{31<<3 | 2}, 40 * time.Millisecond, false},
+		// Code 3 has the frame count in the next byte
+		{[]byte{16<<3 | 3, 48}, 120 * time.Millisecond, false},
+		{[]byte{31<<3 | 3, 0x80 | 6}, 120 * time.Millisecond, false},
+		{[]byte{11<<3 | 3, 2}, 120 * time.Millisecond, false},
+		{[]byte{11<<3 | 3, 3}, 0, true}, // 180 ms is over the limit
+		{[]byte{31<<3 | 3, 0}, 0, true}, // zero frames
+		{[]byte{31<<3 | 3}, 0, true},    // frame count missing
+		{[]byte{}, 0, true},
+	}
+
+	for _, tt := range tests {
+		duration, err := opusPacketDuration(tt.packet)
+		if tt.err {
+			if err == nil {
+				t.Errorf("% x: duration %s, want an error", tt.packet, duration)
+			}
+			continue
+		}
+		if err != nil || duration != tt.duration {
+			t.Errorf("% x: duration %s, %v, want %s", tt.packet, duration, err, tt.duration)
+		}
+	}
+}
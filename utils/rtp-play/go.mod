@@ -0,0 +1,7 @@
+module rtp-play
+
+go 1.21
+
+require voip v0.0.0
+
+replace voip => ../voip
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"voip/pcm"
+)
+
+const (
+	rtpHeaderSize = 12
+	sampleRate    = 8000
+	ptime         = 20 * time.Millisecond
+)
+
+type Config struct {
+	Host        string
+	Port        int
+	File        string
+	Codec       string
+	PayloadType int
+	LocalPort   int
+	Loop        bool
+	Record      string
+	Verbose     bool
+}
+
+// frame is one RTP payload and how much media time it covers
+type frame struct {
+	payload  []byte
+	samples  uint32 // RTP timestamp increment
+	duration time.Duration
+}
+
+func main() {
+	var config Config
+
+	flag.StringVar(&config.Host, "host", "localhost", "Destination host/IP")
+	flag.IntVar(&config.Port, "port", 0, "Destination UDP port")
+	flag.StringVar(&config.File, "file", "", "Audio file to send (WAV, or Ogg Opus for --codec opus)")
+	flag.StringVar(&config.Codec, "codec", "", "Codec: pcmu, pcma or opus (default: opus for .ogg/.opus files, otherwise pcmu)")
+	flag.IntVar(&config.PayloadType, "payload-type", -1, "RTP payload type (default: 0 for PCMU, 8 for PCMA, 111 for Opus)")
+	flag.IntVar(&config.LocalPort, "local-port", 0, "Local UDP port to send from (random if not specified)")
+	flag.BoolVar(&config.Loop, "loop", false, "Repeat the file until interrupted")
+	flag.StringVar(&config.Record, "record", "", "WAV file to write G.711 audio sent back to our port (e.g. by an echo endpoint)")
+	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
+	flag.Parse()
+
+	if config.Port == 0 || config.File == "" {
+		log.Fatalf("--port and --file are required")
+	}
+
+	if config.Codec == "" {
+		switch strings.ToLower(filepath.Ext(config.File)) {
+		case ".ogg", ".opus":
+			config.Codec = "opus"
+		default:
+			config.Codec = "pcmu"
+		}
+	}
+
+	var frames []frame
+	var err error
+	switch config.Codec {
+	case "pcmu", "pcma":
+		frames, err = loadG711Frames(config.File, config.Codec)
+		if config.PayloadType < 0 {
+			config.PayloadType = map[string]int{"pcmu": 0, "pcma": 8}[config.Codec]
+		}
+	case "opus":
+		if config.Record != "" {
+			log.Fatalf("--record only supports PCMU and PCMA; use rtp-dump to capture Opus")
+		}
+		frames, err = loadOpusFrames(config.File)
+		if config.PayloadType < 0 {
+			config.PayloadType = 111
+		}
+	default:
+		log.Fatalf("Unsupported codec: %s", config.Codec)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", config.File, err)
+	}
+	if len(frames) == 0 {
+		log.Fatalf("No audio in %s", config.File)
+	}
+
+	var total time.Duration
+	for _, f := range frames {
+		total += f.duration
+	}
+
+	dest, err := net.ResolveUDPAddr("udp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		log.Fatalf("Failed to resolve destination: %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.LocalPort})
+	if err != nil {
+		log.Fatalf("Failed to open UDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	logf("Sending %s (%s, %d frames, %s) to %s from %s as payload type %d",
+		config.File, strings.ToUpper(config.Codec), len(frames), total.Round(time.Millisecond), dest, conn.LocalAddr(), config.PayloadType)
+
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		close(stop)
+	}()
+
+	// Anything sent back to our port (e.g. by an echo endpoint) is counted, and optionally recorded
+	recorder := &recorder{codec: config.Codec}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		recorder.run(conn, config.Verbose)
+	}()
+
+	sent := send(conn, dest, frames, config, stop)
+
+	// Give the tail of any echoed audio time to come back
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	<-done
+
+	logf("Sent %d packets, received %d back", sent, recorder.received)
+
+	if config.Record != "" {
+		if err := pcm.WriteWAV(config.Record, recorder.samples, sampleRate); err != nil {
+			log.Fatalf("Failed to write %s: %v", config.Record, err)
+		}
+		logf("Recorded %.1fs of returned audio to %s", float64(len(recorder.samples))/sampleRate, config.Record)
+	}
+}
+
+// send paces frames out as RTP in real time and returns the number of packets sent
+func send(conn *net.UDPConn, dest *net.UDPAddr, frames []frame, config Config, stop <-chan struct{}) int {
+	ssrc := rand.Uint32()
+	seq := uint16(rand.Intn(1 << 16))
+	timestamp := rand.Uint32()
+
+	header := make([]byte, rtpHeaderSize)
+	header[0] = 0x80 // Version 2, no padding, no extension, no CSRC
+	binary.BigEndian.PutUint32(header[8:12], ssrc)
+
+	// Packets are scheduled against the start time rather than a ticker, so
+	// frames of different durations (Opus) keep exact overall timing
+	start := time.Now()
+	var elapsed time.Duration
+	sent := 0
+
+	for pass := 0; pass == 0 || config.Loop; pass++ {
+		for i, f := range frames {
+			select {
+			case <-stop:
+				logf("Interrupted")
+				return sent
+			case <-time.After(time.Until(start.Add(elapsed))):
+			}
+
+			header[1] = byte(config.PayloadType & 0x7f)
+			if sent == 0 {
+				header[1] |= 0x80 // Marker bit on the first packet of the talkspurt
+			}
+			binary.BigEndian.PutUint16(header[2:4], seq)
+			binary.BigEndian.PutUint32(header[4:8], timestamp)
+
+			if _, err := conn.WriteToUDP(append(header, f.payload...), dest); err != nil {
+				log.Printf("Failed to send packet %d: %v", i, err)
+			} else {
+				sent++
+			}
+
+			seq++
+			timestamp += f.samples
+			elapsed += f.duration
+		}
+
+		if config.Verbose {
+			logf("Finished pass %d after %s", pass+1, elapsed.Round(time.Millisecond))
+		}
+	}
+
+	return sent
+}
+
+// recorder counts RTP coming back on our socket and decodes G.711 into a timestamp-ordered buffer
+type recorder struct {
+	codec    string
+	received int
+	samples  []int16
+}
+
+func (r *recorder) run(conn *net.UDPConn, verbose bool) {
+	buffer := make([]byte, 1500)
+	var firstTimestamp uint32
+	started := false
+
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		if n < rtpHeaderSize || buffer[0]>>6 != 2 {
+			continue
+		}
+
+		r.received++
+		if r.received == 1 && verbose {
+			logf("Receiving RTP from %s", from)
+		}
+
+		timestamp := binary.BigEndian.Uint32(buffer[4:8])
+		if !started {
+			started = true
+			firstTimestamp = timestamp
+		}
+
+		// Place audio by timestamp so lost packets become silence; ignore wild jumps
+		offset := int(timestamp - firstTimestamp)
+		headerSize := rtpHeaderSize + int(buffer[0]&0x0f)*4
+		if buffer[0]&0x10 != 0 && n >= headerSize+4 {
+			headerSize += 4 + int(binary.BigEndian.Uint16(buffer[headerSize+2:headerSize+4]))*4
+		}
+		payloadEnd := n
+		if buffer[0]&0x20 != 0 && payloadEnd > headerSize {
+			payloadEnd -= int(buffer[payloadEnd-1])
+		}
+		if r.codec != "opus" && headerSize <= payloadEnd && offset >= 0 && offset-len(r.samples) < 10*sampleRate {
+			payload := buffer[headerSize:payloadEnd]
+			if end := offset + len(payload); end > len(r.samples) {
+				r.samples = append(r.samples, make([]int16, end-len(r.samples))...)
+			}
+			for i, b := range payload {
+				r.samples[offset+i] = pcm.DecodeG711(b, r.codec)
+			}
+		}
+	}
+}
+
+// loadG711Frames reads a WAV file and splits it into 20 ms G.711 payloads at 8 kHz
+func loadG711Frames(path, codec string) ([]frame, error) {
+	samples, rate, err := pcm.ReadWAV(path)
+	if err != nil {
+		return nil, err
+	}
+	samples = pcm.Resample(samples, rate, sampleRate)
+
+	samplesPerFrame := sampleRate * int(ptime/time.Millisecond) / 1000
+	var frames []frame
+	for start := 0; start < len(samples); start += samplesPerFrame {
+		payload := make([]byte, samplesPerFrame)
+		for i := range payload {
+			// The last frame is padded with silence
+			var sample int16
+			if start+i < len(samples) {
+				sample = samples[start+i]
+			}
+			payload[i] = pcm.EncodeG711(sample, codec)
+		}
+		frames = append(frames, frame{payload: payload, samples: uint32(samplesPerFrame), duration: ptime})
+	}
+	return frames, nil
+}
+
+// logf prints a timestamped log message
+func logf(format string, args ...interface{}) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+	fmt.Printf("[%s] %s\n", timestamp, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Opus RTP always uses a 48 kHz clock, whatever the encoder's internal rate (RFC 7587)
+const opusClockRate = 48000
+
+// loadOpusFrames reads an Ogg Opus file and returns its audio packets ready to send as RTP
+func loadOpusFrames(path string) ([]frame, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	packets, err := readOggPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) < 2 || !bytes.HasPrefix(packets[0], []byte("OpusHead")) {
+		return nil, fmt.Errorf("not an Ogg Opus file")
+	}
+
+	// The first two packets are the OpusHead and OpusTags headers
+	var frames []frame
+	for i, packet := range packets[2:] {
+		duration, err := opusPacketDuration(packet)
+		if err != nil {
+			return nil, fmt.Errorf("packet %d: %v", i, err)
+		}
+		frames = append(frames, frame{
+			payload:  packet,
+			samples:  uint32(duration * opusClockRate / time.Second),
+			duration: duration,
+		})
+	}
+	return frames, nil
+}
+
+// readOggPackets returns the packets of the first logical stream in an Ogg file
+func readOggPackets(data []byte) ([][]byte, error) {
+	var packets [][]byte
+	var partial []byte
+	var serial uint32
+	haveSerial := false
+
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 27 || string(data[offset:offset+4]) != "OggS" {
+			return nil, fmt.Errorf("invalid Ogg page at offset %d", offset)
+		}
+		page := data[offset:]
+		segmentCount := int(page[26])
+		if len(page) < 27+segmentCount {
+			return nil, fmt.Errorf("truncated Ogg page at offset %d", offset)
+		}
+		lacing := page[27 : 27+segmentCount]
+
+		bodySize := 0
+		for _, size := range lacing {
+			bodySize += int(size)
+		}
+		body := page[27+segmentCount:]
+		if len(body) < bodySize {
+			return nil, fmt.Errorf("truncated Ogg page at offset %d", offset)
+		}
+		offset += 27 + segmentCount + bodySize
+
+		// Chained or multiplexed streams are not supported; play only the first one
+		pageSerial := binary.LittleEndian.Uint32(page[14:18])
+		if !haveSerial {
+			serial = pageSerial
+			haveSerial = true
+		} else if pageSerial != serial {
+			continue
+		}
+
+		// Packets are split into 255-byte segments; a shorter segment ends a packet,
+		// and a packet still open at the end of a page continues on the next one
+		position := 0
+		for _, size := range lacing {
+			partial = append(partial, body[position:position+int(size)]...)
+			position += int(size)
+			if size < 255 {
+				packets = append(packets, partial)
+				partial = nil
+			}
+		}
+	}
+
+	return packets, nil
+}
+
+// opusPacketDuration returns the audio duration of an Opus packet from its TOC byte (RFC 6716 section 3.1)
+func opusPacketDuration(packet []byte) (time.Duration, error) {
+	if len(packet) < 1 {
+		return 0, fmt.Errorf("empty Opus packet")
+	}
+
+	toc := packet[0]
+	config := int(toc >> 3)
+
+	var frameDuration time.Duration
+	switch {
+	case config < 12: // SILK-only: 10, 20, 40, 60 ms
+		frameDuration = []time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // Hybrid: 10, 20 ms
+		frameDuration = []time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT-only: 2.5, 5, 10, 20 ms
+		frameDuration = []time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	var frameCount int
+	switch toc & 0x03 {
+	case 0:
+		frameCount = 1
+	case 1, 2:
+		frameCount = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0, fmt.Errorf("truncated Opus packet")
+		}
+		frameCount = int(packet[1] & 0x3f)
+	}
+
+	duration := time.Duration(frameCount) * frameDuration
+	if duration == 0 || duration > 120*time.Millisecond {
+		return 0, fmt.Errorf("invalid Opus packet duration %s", duration)
+	}
+	return duration, nil
+}
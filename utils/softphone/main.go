@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"voip/netutil"
+	"voip/pcm"
+	"voip/sip"
+)
+
+type Config struct {
+	Server    string
+	User      string
+	AuthUser  string
+	Password  string
+	Domain    string
+	LocalPort int
+	PublicIP  string
+	RTPPort   int
+	Register  bool
+	Expires   int
+	Call      string
+	Answer    bool
+	Play      string
+	Record    string
+	Duration  time.Duration
+	Timeout   int
+	Verbose   bool
+}
+
+func main() {
+	var config Config
+
+	flag.StringVar(&config.Server, "server", "", "SIP server/outbound proxy (host:port); all requests are sent through it")
+	flag.StringVar(&config.User, "user", "softphone", "SIP user (the user part of our address of record)")
+	flag.StringVar(&config.AuthUser, "auth-user", "", "Digest authentication username (defaults to --user)")
+	flag.StringVar(&config.Password, "password", "", "Digest authentication password")
+	flag.StringVar(&config.Domain, "domain", "", "SIP domain (defaults to the --server host)")
+	flag.IntVar(&config.LocalPort, "local-port", 0, "Local UDP port for SIP (random if not specified)")
+	flag.StringVar(&config.PublicIP, "public-ip", "", "IP address to advertise in Contact/Via and SDP (auto-detected if not specified)")
+	flag.IntVar(&config.RTPPort, "rtp-port", 0, "Local UDP port for RTP (random if not specified)")
+	flag.BoolVar(&config.Register, "register", false, "Register with --server before calling or answering")
+	flag.IntVar(&config.Expires, "expires", 300, "Requested registration lifetime in seconds")
+	flag.StringVar(&config.Call, "call", "", "SIP URI or user to call (e.g. sip:echo@localhost or echo)")
+	flag.BoolVar(&config.Answer, "answer", false, "Wait for an incoming call and answer it")
+	flag.StringVar(&config.Play, "play", "", "WAV file to send as call audio (silence if not specified)")
+	flag.StringVar(&config.Record, "record", "", "WAV file to write received call audio to")
+	flag.DurationVar(&config.Duration, "duration", 0, "Hang up after this long (0 = when --play finishes, otherwise when the other side hangs up)")
+	flag.IntVar(&config.Timeout, "timeout", 60, "Seconds to wait for an answer or an incoming call")
+	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging, including full SIP messages")
+	flag.Parse()
+
+	if !config.Register && config.Call == "" && !config.Answer {
+		log.Fatalf("Nothing to do: specify --register, --call or --answer")
+	}
+	if config.Call != "" && config.Answer {
+		log.Fatalf("--call and --answer are mutually exclusive")
+	}
+	if config.Register && config.Server == "" {
+		log.Fatalf("--register requires --server")
+	}
+	if config.AuthUser == "" {
+		config.AuthUser = config.User
+	}
+	if config.Domain == "" && config.Server != "" {
+		host, _, err := net.SplitHostPort(config.Server)
+		if err != nil {
+			host = config.Server
+		}
+		config.Domain = host
+	}
+
+	if config.PublicIP == "" {
+		ip, err := netutil.DefaultRouteIP()
+		if err != nil {
+			log.Fatalf("Failed to detect local IP, specify --public-ip: %v", err)
+		}
+		config.PublicIP = ip
+	}
+
+	if config.Call != "" {
+		target, err := sip.NormalizeTarget(config.Call, config.Domain)
+		if err != nil {
+			log.Fatalf("Invalid --call: %v", err)
+		}
+		config.Call = target
+	}
+
+	var audio []int16
+	if config.Play != "" {
+		samples, rate, err := pcm.ReadWAV(config.Play)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", config.Play, err)
+		}
+		audio = pcm.Resample(samples, rate, sampleRate)
+		logf("Loaded %s (%d Hz, %.1fs)", config.Play, rate, float64(len(audio))/sampleRate)
+	}
+
+	ua, err := sip.NewUserAgent(sip.Config{
+		Name:      "softphone",
+		Server:    config.Server,
+		User:      config.User,
+		AuthUser:  config.AuthUser,
+		Password:  config.Password,
+		Domain:    config.Domain,
+		LocalPort: config.LocalPort,
+		PublicIP:  config.PublicIP,
+		RTPPort:   config.RTPPort,
+		Ptime:     int(ptime / time.Millisecond),
+		Expires:   config.Expires,
+		Answer:    config.Answer,
+		Timeout:   config.Timeout,
+		Trace:     config.Verbose,
+		Logf:      logf,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start SIP user agent: %v", err)
+	}
+	defer ua.Close()
+
+	logf("SIP listening on %s, advertising %s", ua.LocalAddr(), ua.ContactHost())
+
+	// The first signal ends the call (or registration) cleanly, a second one exits immediately
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		logf("Shutdown signal received, hanging up...")
+		close(stop)
+		<-sigChan
+		os.Exit(1)
+	}()
+
+	if config.Register {
+		if err := ua.Register(config.Expires); err != nil {
+			log.Fatalf("Registration failed: %v", err)
+		}
+		go ua.RefreshRegistration(stop)
+		defer func() {
+			if err := ua.Register(0); err != nil {
+				log.Printf("Failed to unregister: %v", err)
+			}
+		}()
+	}
+
+	err = nil
+	switch {
+	case config.Call != "":
+		err = runCall(ua, config, audio, stop, func() (*sip.Dialog, error) { return ua.Invite(config.Call, stop) })
+	case config.Answer:
+		err = runCall(ua, config, audio, stop, func() (*sip.Dialog, error) { return ua.Answer(stop) })
+	default:
+		logf("Registered, press Ctrl+C to unregister and exit")
+		<-stop
+	}
+
+	if err != nil {
+		// Deferred unregistration would be skipped by log.Fatalf
+		log.Printf("Error: %v", err)
+		if config.Register {
+			ua.Register(0)
+		}
+		ua.Close()
+		os.Exit(1)
+	}
+}
+
+// runCall sets up a call with establish, exchanges media and hangs up
+func runCall(ua *sip.UserAgent, config Config, audio []int16, stop <-chan struct{}, establish func() (*sip.Dialog, error)) error {
+	d, err := establish()
+	if err != nil {
+		return err
+	}
+
+	logf("Call established (Call-ID %s), media to %s", d.CallID(), d.RemoteMedia())
+
+	result, err := runMedia(d, audio, config, stop)
+	if err != nil {
+		ua.Bye(d)
+		return err
+	}
+
+	if result.reason != "remote hung up" {
+		if err := ua.Bye(d); err != nil {
+			log.Printf("Failed to hang up: %v", err)
+		}
+	}
+
+	logf("Call ended: %s after %s", result.reason, result.duration.Round(time.Millisecond))
+	logf("RTP: sent %d packets, received %d, lost %d, reordered %d", result.sent, result.received, result.lost, result.reordered)
+
+	if config.Record != "" {
+		if err := pcm.WriteWAV(config.Record, result.recorded, sampleRate); err != nil {
+			return fmt.Errorf("failed to write %s: %v", config.Record, err)
+		}
+		logf("Recorded %.1fs of received audio to %s", float64(len(result.recorded))/sampleRate, config.Record)
+	}
+
+	if result.received == 0 {
+		return fmt.Errorf("no RTP received from %s", d.RemoteMedia())
+	}
+	return nil
+}
+
+// logf prints a timestamped log message
+func logf(format string, args ...interface{}) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+	fmt.Printf("[%s] %s\n", timestamp, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"voip/pcm"
+	"voip/sip"
+)
+
+const (
+	rtpHeaderSize    = 12
+	sampleRate       = 8000
+	ptime            = 20 * time.Millisecond
+	samplesPerPacket = sampleRate * int(ptime/time.Millisecond) / 1000
+
+	// Received audio is placed by RTP timestamp; jumps beyond this are treated as a new stream
+	maxTimestampJump = 10 * sampleRate
+)
+
+// mediaResult summarizes the RTP exchanged during a call
+type mediaResult struct {
+	reason    string
+	duration  time.Duration
+	sent      int
+	received  int
+	lost      int
+	reordered int
+	recorded  []int16
+}
+
+// runMedia sends audio (or silence) as paced PCMU RTP and records what comes back, until the call ends
+func runMedia(d *sip.Dialog, audio []int16, config Config, stop <-chan struct{}) (*mediaResult, error) {
+	conn := d.MediaConn()
+	defer conn.Close()
+
+	result := &mediaResult{}
+	start := time.Now()
+
+	var mu sync.Mutex
+	signalled := d.RemoteMedia() // the address from SDP, which a re-INVITE may change
+	remote := signalled
+	latched := false
+
+	// Receive side: decode PCMU into a buffer indexed by RTP timestamp, so lost packets become silence
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		buffer := make([]byte, 1500)
+		var firstTimestamp uint32
+		var highestSeq int64 // Extended sequence number, so wraparound does not look like reordering
+		seen := make(map[int64]bool)
+		started := false
+
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if n < rtpHeaderSize || buffer[0]>>6 != 2 {
+				continue
+			}
+
+			headerSize := rtpHeaderSize + int(buffer[0]&0x0f)*4
+			if buffer[0]&0x10 != 0 && n >= headerSize+4 {
+				headerSize += 4 + int(binary.BigEndian.Uint16(buffer[headerSize+2:headerSize+4]))*4
+			}
+			end := n
+			if buffer[0]&0x20 != 0 && end > headerSize {
+				end -= int(buffer[end-1])
+			}
+			if headerSize > end {
+				continue
+			}
+
+			payloadType := buffer[1] & 0x7f
+			seq := binary.BigEndian.Uint16(buffer[2:4])
+			timestamp := binary.BigEndian.Uint32(buffer[4:8])
+
+			mu.Lock()
+			// Symmetric RTP: send to wherever the far end's media actually comes from,
+			// which differs from the SDP address when it is behind NAT
+			if !latched {
+				latched = true
+				if from.String() != remote.String() {
+					if config.Verbose {
+						logf("RTP: Media arrives from %s instead of %s, switching to it", from, remote)
+					}
+					remote = from
+				}
+			}
+
+			if !started {
+				started = true
+				firstTimestamp = timestamp
+				highestSeq = int64(seq) - 1
+			}
+
+			// Extend the 16-bit sequence number relative to the highest one seen
+			extended := highestSeq + int64(int16(seq-uint16(highestSeq)))
+			if seen[extended] {
+				mu.Unlock()
+				continue
+			}
+			seen[extended] = true
+			result.received++
+
+			switch {
+			case extended > highestSeq:
+				result.lost += int(extended - highestSeq - 1)
+				highestSeq = extended
+			case extended < highestSeq:
+				// A late packet fills a gap that was counted as lost
+				result.reordered++
+				if result.lost > 0 {
+					result.lost--
+				}
+			}
+
+			// Forget old sequence numbers so long calls do not grow the map without bound
+			if len(seen) > 4096 {
+				for s := range seen {
+					if s < highestSeq-1024 {
+						delete(seen, s)
+					}
+				}
+			}
+
+			if payloadType == sip.PayloadTypePCMU {
+				offset := int(timestamp - firstTimestamp)
+				if offset >= 0 && offset-len(result.recorded) < maxTimestampJump {
+					payload := buffer[headerSize:end]
+					if end := offset + len(payload); end > len(result.recorded) {
+						result.recorded = append(result.recorded, make([]int16, end-len(result.recorded))...)
+					}
+					for i, b := range payload {
+						result.recorded[offset+i] = pcm.ULawToLinear(b)
+					}
+				}
+			} else if config.Verbose {
+				logf("RTP: Ignoring payload type %d from %s", payloadType, from)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	ssrc := rand.Uint32()
+	seq := uint16(rand.Intn(1 << 16))
+	timestamp := rand.Uint32()
+
+	packet := make([]byte, rtpHeaderSize+samplesPerPacket)
+	packet[0] = 0x80                       // Version 2, no padding, no extension, no CSRC
+	packet[1] = sip.PayloadTypePCMU | 0x80 // Marker bit on the first packet
+	binary.BigEndian.PutUint32(packet[8:12], ssrc)
+
+	var deadline <-chan time.Time
+	if config.Duration > 0 {
+		deadline = time.After(config.Duration)
+	}
+
+	ticker := time.NewTicker(ptime)
+	defer ticker.Stop()
+
+	for i := 0; ; i++ {
+		if config.Duration == 0 && audio != nil && i*samplesPerPacket >= len(audio) {
+			result.reason = "playback finished"
+			break
+		}
+
+		// Keep sending silence after the file ends so NAT bindings and media timeouts stay happy
+		for j := 0; j < samplesPerPacket; j++ {
+			var sample int16
+			if k := i*samplesPerPacket + j; k < len(audio) {
+				sample = audio[k]
+			}
+			packet[rtpHeaderSize+j] = pcm.LinearToULaw(sample)
+		}
+		binary.BigEndian.PutUint16(packet[2:4], seq+uint16(i))
+		binary.BigEndian.PutUint32(packet[4:8], timestamp+uint32(i*samplesPerPacket))
+
+		mu.Lock()
+		if current := d.RemoteMedia(); current != signalled {
+			// Follow the new address, latching again onto wherever its media comes from
+			signalled, remote, latched = current, current, false
+		}
+		target := remote
+		mu.Unlock()
+
+		if _, err := conn.WriteToUDP(packet, target); err != nil {
+			return nil, fmt.Errorf("failed to send RTP to %s: %v", target, err)
+		}
+		packet[1] &^= 0x80
+		result.sent++
+
+		select {
+		case <-ticker.C:
+			continue
+		case <-d.Ended():
+			result.reason = d.Reason()
+		case <-stop:
+			result.reason = "interrupted"
+		case <-deadline:
+			result.reason = "duration reached"
+		}
+		break
+	}
+
+	// Let the tail of the far end's audio arrive before closing the socket
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	<-done
+
+	result.duration = time.Since(start)
+	return result, nil
+}
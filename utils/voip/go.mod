@@ -0,0 +1,3 @@
+module voip
+
+go 1.21
@@ -0,0 +1,54 @@
+// Package netutil finds the local address the SIP and RTP utilities advertise to the other side
+package netutil
+
+import (
+	"fmt"
+	"net"
+)
+
+// DefaultRouteIP returns the local IPv4 address used to reach the default gateway
+func DefaultRouteIP() (string, error) {
+	// Connecting a UDP socket sends no packets but makes the OS pick a source
+	// address from its routing table, which works the same on every platform
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err == nil {
+		defer conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && !addr.IP.IsUnspecified() {
+			return addr.IP.String(), nil
+		}
+	}
+
+	// No default route (e.g. an isolated container), so fall back to the first usable interface
+	return firstInterfaceIP()
+}
+
+// firstInterfaceIP returns the first IPv4 address of an interface that is up and not loopback
+func firstInterfaceIP() (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list interfaces: %v", err)
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ip := ipNet.IP.To4(); ip != nil && !ip.IsLinkLocalUnicast() {
+				return ip.String(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("could not find a non-loopback IPv4 address")
+}
@@ -0,0 +1,99 @@
+package pcm
+
+// EncodeG711 encodes a 16-bit linear PCM sample as mu-law ("pcmu") or A-law ("pcma")
+func EncodeG711(sample int16, codec string) byte {
+	if codec == "pcma" {
+		return LinearToALaw(sample)
+	}
+	return LinearToULaw(sample)
+}
+
+// DecodeG711 decodes a mu-law ("pcmu") or A-law ("pcma") byte to 16-bit linear PCM
+func DecodeG711(b byte, codec string) int16 {
+	if codec == "pcma" {
+		return ALawToLinear(b)
+	}
+	return ULawToLinear(b)
+}
+
+// LinearToULaw encodes a 16-bit linear PCM sample as G.711 mu-law
+func LinearToULaw(sample int16) byte {
+	const bias = 0x84
+	const clip = 32635
+
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0f
+
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// ULawToLinear decodes a G.711 mu-law byte to a 16-bit linear PCM sample
+func ULawToLinear(u byte) int16 {
+	u = ^u
+	exponent := int(u>>4) & 0x07
+	mantissa := int(u) & 0x0f
+
+	sample := ((mantissa << 3) + 0x84) << exponent
+	sample -= 0x84
+	if u&0x80 != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// LinearToALaw encodes a 16-bit linear PCM sample as G.711 A-law
+func LinearToALaw(sample int16) byte {
+	s := int(sample) >> 3 // A-law works on 13-bit magnitudes
+	sign := 0x80
+	if s < 0 {
+		s = -s - 1
+		sign = 0
+	}
+	if s > 0xfff {
+		s = 0xfff
+	}
+
+	var encoded int
+	if s < 32 {
+		encoded = s >> 1
+	} else {
+		exponent := 1
+		for v := s >> 5; v > 1; v >>= 1 {
+			exponent++
+		}
+		encoded = exponent<<4 | (s>>exponent)&0x0f
+	}
+
+	// Even bits are inverted to help line clock recovery
+	return byte(sign|encoded) ^ 0x55
+}
+
+// ALawToLinear decodes a G.711 A-law byte to a 16-bit linear PCM sample
+func ALawToLinear(a byte) int16 {
+	a ^= 0x55
+	exponent := int(a>>4) & 0x07
+	mantissa := int(a) & 0x0f
+
+	sample := mantissa<<4 + 8
+	if exponent > 0 {
+		sample = (sample + 0x100) << (exponent - 1)
+	}
+	if a&0x80 == 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
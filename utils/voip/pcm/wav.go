@@ -0,0 +1,168 @@
+// Package pcm reads and writes 16-bit linear PCM audio as WAV files and converts it to and from G.711
+package pcm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatALaw       = 6
+	wavFormatMuLaw      = 7
+	wavFormatExtensible = 0xFFFE
+)
+
+// ReadWAV reads a 16-bit PCM or 8-bit G.711 WAV file and returns its samples mixed down to mono
+func ReadWAV(path string) ([]int16, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, fmt.Errorf("not a WAV file")
+	}
+
+	var format, channels, bitsPerSample int
+	var rate int
+	var samples []byte
+	haveFormat := false
+
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := data[offset+8:]
+		if size > len(body) {
+			// Streamed WAVs sometimes leave the data size unset; use what is there
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, fmt.Errorf("fmt chunk too short")
+			}
+			format = int(binary.LittleEndian.Uint16(body[0:2]))
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			if format == wavFormatExtensible && size >= 26 {
+				format = int(binary.LittleEndian.Uint16(body[24:26]))
+			}
+			haveFormat = true
+		case "data":
+			samples = body
+		}
+
+		// Chunks are padded to an even size
+		offset += 8 + size + size%2
+	}
+
+	if !haveFormat || samples == nil {
+		return nil, 0, fmt.Errorf("missing fmt or data chunk")
+	}
+	if channels < 1 || rate < 1 {
+		return nil, 0, fmt.Errorf("invalid format: %d channels at %d Hz", channels, rate)
+	}
+
+	var decoded []int16
+	switch {
+	case format == wavFormatPCM && bitsPerSample == 16:
+		decoded = make([]int16, len(samples)/2)
+		for i := range decoded {
+			decoded[i] = int16(binary.LittleEndian.Uint16(samples[i*2:]))
+		}
+	case (format == wavFormatMuLaw || format == wavFormatALaw) && bitsPerSample == 8:
+		codec := "pcmu"
+		if format == wavFormatALaw {
+			codec = "pcma"
+		}
+		decoded = make([]int16, len(samples))
+		for i, b := range samples {
+			decoded[i] = DecodeG711(b, codec)
+		}
+	default:
+		return nil, 0, fmt.Errorf("unsupported encoding (format %d, %d bits); convert to 16-bit PCM first", format, bitsPerSample)
+	}
+
+	if channels == 1 {
+		return decoded, rate, nil
+	}
+
+	mono := make([]int16, len(decoded)/channels)
+	for i := range mono {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += int(decoded[i*channels+c])
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return mono, rate, nil
+}
+
+// Resample converts samples between rates, averaging when downsampling and interpolating
+// linearly when upsampling, which is adequate for test prompts
+func Resample(samples []int16, from, to int) []int16 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+
+	// Average over the source samples that fold into each output sample, a crude low-pass against aliasing
+	window := from / to
+	if window < 1 {
+		window = 1
+	}
+
+	out := make([]int16, int(int64(len(samples))*int64(to)/int64(from)))
+	for i := range out {
+		position := float64(i) * float64(from) / float64(to)
+		index := int(position)
+
+		if window > 1 {
+			sum, count := 0, 0
+			for j := index; j < index+window && j < len(samples); j++ {
+				sum += int(samples[j])
+				count++
+			}
+			out[i] = int16(sum / count)
+			continue
+		}
+
+		next := index + 1
+		if next >= len(samples) {
+			next = len(samples) - 1
+		}
+		fraction := position - float64(index)
+		out[i] = int16(float64(samples[index])*(1-fraction) + float64(samples[next])*fraction)
+	}
+	return out
+}
+
+// WriteWAV writes mono 16-bit PCM samples as a WAV file
+func WriteWAV(path string, samples []int16, rate int) error {
+	dataSize := len(samples) * 2
+	data := make([]byte, 44+dataSize)
+
+	copy(data[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(data[4:8], uint32(36+dataSize))
+	copy(data[8:12], "WAVE")
+
+	copy(data[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(data[16:20], 16)
+	binary.LittleEndian.PutUint16(data[20:22], wavFormatPCM)
+	binary.LittleEndian.PutUint16(data[22:24], 1)
+	binary.LittleEndian.PutUint32(data[24:28], uint32(rate))
+	binary.LittleEndian.PutUint32(data[28:32], uint32(rate*2))
+	binary.LittleEndian.PutUint16(data[32:34], 2)
+	binary.LittleEndian.PutUint16(data[34:36], 16)
+
+	copy(data[36:40], "data")
+	binary.LittleEndian.PutUint32(data[40:44], uint32(dataSize))
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(data[44+i*2:], uint16(sample))
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,169 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wavFile builds a WAV file with the given format and data, placing any extra chunks before the data chunk
+func wavFile(format, channels, rate, bits int, extra []byte, data []byte) []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], uint16(format))
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(rate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(rate*channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], uint16(channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], uint16(bits))
+	if format == wavFormatExtensible {
+		// cbSize, valid bits, channel mask, then the sub-format GUID whose first two bytes are the real format
+		ext := make([]byte, 24)
+		binary.LittleEndian.PutUint16(ext[0:2], 22)
+		binary.LittleEndian.PutUint16(ext[2:4], uint16(bits))
+		binary.LittleEndian.PutUint16(ext[8:10], wavFormatPCM)
+		fmtChunk = append(fmtChunk, ext...)
+	}
+
+	var body []byte
+	body = append(body, chunk("fmt ", fmtChunk)...)
+	body = append(body, extra...)
+	body = append(body, chunk("data", data)...)
+	return chunk("RIFF", append([]byte("WAVE"), body...))
+}
+
+// chunk returns a RIFF chunk, padded to an even size
+func chunk(id string, body []byte) []byte {
+	out := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	out = append(out, body...)
+	if len(body)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// pcm16 encodes samples as 16-bit little-endian PCM
+func pcm16(samples ...int16) []byte {
+	var out []byte
+	for _, s := range samples {
+		out = binary.LittleEndian.AppendUint16(out, uint16(s))
+	}
+	return out
+}
+
+func TestReadWAV(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    []byte
+		samples []int16
+		rate    int
+		err     string
+	}{
+		{
+			name:    "16-bit mono",
+			file:    wavFile(wavFormatPCM, 1, 16000, 16, nil, pcm16(0, 1000, -1000, 32767)),
+			samples: []int16{0, 1000, -1000, 32767},
+			rate:    16000,
+		},
+		{
+			name:    "16-bit stereo is mixed down",
+			file:    wavFile(wavFormatPCM, 2, 44100, 16, nil, pcm16(1000, 3000, -200, 200)),
+			samples: []int16{2000, 0},
+			rate:    44100,
+		},
+		{
+			name:    "extensible 16-bit",
+			file:    wavFile(wavFormatExtensible, 1, 8000, 16, nil, pcm16(5, -5)),
+			samples: []int16{5, -5},
+			rate:    8000,
+		},
+		{
+			name:    "mu-law",
+			file:    wavFile(wavFormatMuLaw, 1, 8000, 8, nil, []byte{0xff, 0x80, 0x00}),
+			samples: []int16{0, 32124, -32124},
+			rate:    8000,
+		},
+		{
+			name:    "A-law",
+			file:    wavFile(wavFormatALaw, 1, 8000, 8, nil, []byte{0xd5, 0x55}),
+			samples: []int16{8, -8},
+			rate:    8000,
+		},
+		{
+			name:    "odd-sized chunk before data",
+			file:    wavFile(wavFormatPCM, 1, 8000, 16, chunk("LIST", []byte("abc")), pcm16(7)),
+			samples: []int16{7},
+			rate:    8000,
+		},
+		{
+			name: "streamed data size",
+			file: func() []byte {
+				file := wavFile(wavFormatPCM, 1, 8000, 16, nil, pcm16(1, 2))
+				binary.LittleEndian.PutUint32(file[len(file)-8:], 0xffffffff)
+				return file
+			}(),
+			samples: []int16{1, 2},
+			rate:    8000,
+		},
+		{name: "not RIFF", file: []byte("OggS0000WAVE"), err: "not a WAV file"},
+		{name: "too short", file: []byte("RIFF"), err: "not a WAV file"},
+		{name: "no data chunk", file: append([]byte("RIFF\x00\x00\x00\x00WAVE"), chunk("fmt ", make([]byte, 16))...), err: "missing fmt or data chunk"},
+		{name: "short fmt chunk", file: append([]byte("RIFF\x00\x00\x00\x00WAVE"), chunk("fmt ", make([]byte, 8))...), err: "fmt chunk too short"},
+		{name: "zero channels", file: wavFile(wavFormatPCM, 0, 8000, 16, nil, pcm16(1)), err: "invalid format"},
+		{name: "8-bit PCM", file: wavFile(wavFormatPCM, 1, 8000, 8, nil, []byte{1, 2}), err: "unsupported encoding"},
+		{name: "float", file: wavFile(3, 1, 8000, 32, nil, make([]byte, 8)), err: "unsupported encoding"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.wav")
+			if err := os.WriteFile(path, tt.file, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			samples, rate, err := ReadWAV(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rate != tt.rate {
+				t.Errorf("rate = %d, want %d", rate, tt.rate)
+			}
+			if len(samples) != len(tt.samples) {
+				t.Fatalf("samples = %v, want %v", samples, tt.samples)
+			}
+			for i := range samples {
+				if samples[i] != tt.samples[i] {
+					t.Fatalf("samples = %v, want %v", samples, tt.samples)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteWAVRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+	want := []int16{0, 1, -1, 32767, -32768}
+	if err := WriteWAV(path, want, 8000); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, rate, err := ReadWAV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 8000 || len(samples) != len(want) {
+		t.Fatalf("read back %d samples at %d Hz, want %d at 8000", len(samples), rate, len(want))
+	}
+	for i := range want {
+		if samples[i] != want[i] {
+			t.Fatalf("samples = %v, want %v", samples, want)
+		}
+	}
+}
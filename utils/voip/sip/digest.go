@@ -0,0 +1,59 @@
+package sip
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// digestAuthorization answers an RFC 2617 MD5 digest challenge
+func digestAuthorization(challenge, method, uri, username, password string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+
+	values := make(map[string]string)
+	for _, param := range splitHeaderList(params) {
+		key, value, _ := strings.Cut(param, "=")
+		values[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	if algorithm := values["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	md5Hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	realm, nonce := values["realm"], values["nonce"]
+	ha1 := md5Hex(username + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5`, username, realm, nonce, uri)
+
+	qopAuth := false
+	for _, qop := range strings.Split(values["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			qopAuth = true
+		}
+	}
+
+	if qopAuth {
+		const nc = "00000001"
+		cnonce := randomHex(8)
+		response := md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		header += fmt.Sprintf(`, response="%s", qop=auth, nc=%s, cnonce="%s"`, response, nc, cnonce)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+nonce+":"+ha2))
+	}
+
+	if opaque, ok := values["opaque"]; ok {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+
+	return header, nil
+}
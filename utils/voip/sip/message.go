@@ -0,0 +1,265 @@
+// Package sip is a minimal SIP user agent over UDP, shared by the softphone and rtp-bench utilities
+package sip
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// compactHeaders maps RFC 3261 compact header names to their full form
+var compactHeaders = map[string]string{
+	"v": "via",
+	"f": "from",
+	"t": "to",
+	"i": "call-id",
+	"m": "contact",
+	"l": "content-length",
+	"c": "content-type",
+	"k": "supported",
+}
+
+type sipHeader struct {
+	name  string
+	value string
+}
+
+// sipMessage is a parsed SIP request or response
+type sipMessage struct {
+	method     string // set for requests
+	requestURI string
+	statusCode int // set for responses
+	reason     string
+	headers    []sipHeader
+	body       []byte
+}
+
+func (m *sipMessage) isRequest() bool {
+	return m.method != ""
+}
+
+// parseSIPMessage parses a single SIP message received over UDP
+func parseSIPMessage(data []byte) (*sipMessage, error) {
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	if headerEnd == -1 {
+		return nil, fmt.Errorf("no end of headers")
+	}
+
+	lines := strings.Split(string(data[:headerEnd]), "\r\n")
+	msg := &sipMessage{body: data[headerEnd+4:]}
+
+	startLine := strings.SplitN(lines[0], " ", 3)
+	if len(startLine) != 3 {
+		return nil, fmt.Errorf("malformed start line %q", lines[0])
+	}
+	if strings.HasPrefix(startLine[0], "SIP/") {
+		code, err := strconv.Atoi(startLine[1])
+		if err != nil || code < 100 || code > 699 {
+			return nil, fmt.Errorf("invalid status code %q", startLine[1])
+		}
+		msg.statusCode = code
+		msg.reason = startLine[2]
+	} else {
+		if startLine[2] != "SIP/2.0" {
+			return nil, fmt.Errorf("unsupported version %q", startLine[2])
+		}
+		msg.method = startLine[0]
+		msg.requestURI = startLine[1]
+	}
+
+	for _, line := range lines[1:] {
+		// Folded continuation lines belong to the previous header
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(msg.headers) > 0 {
+			msg.headers[len(msg.headers)-1].value += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed header %q", line)
+		}
+		msg.headers = append(msg.headers, sipHeader{name: strings.TrimSpace(name), value: strings.TrimSpace(value)})
+	}
+
+	if length := msg.header("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n > len(msg.body) {
+			return nil, fmt.Errorf("invalid Content-Length %q", length)
+		}
+		msg.body = msg.body[:n]
+	}
+
+	return msg, nil
+}
+
+func canonicalHeaderName(name string) string {
+	name = strings.ToLower(name)
+	if full, ok := compactHeaders[name]; ok {
+		return full
+	}
+	return name
+}
+
+// header returns the first value of the named header, or ""
+func (m *sipMessage) header(name string) string {
+	name = canonicalHeaderName(name)
+	for _, h := range m.headers {
+		if canonicalHeaderName(h.name) == name {
+			return h.value
+		}
+	}
+	return ""
+}
+
+// headerValues returns every value of the named header, splitting comma-separated lists
+func (m *sipMessage) headerValues(name string) []string {
+	name = canonicalHeaderName(name)
+	var values []string
+	for _, h := range m.headers {
+		if canonicalHeaderName(h.name) == name {
+			values = append(values, splitHeaderList(h.value)...)
+		}
+	}
+	return values
+}
+
+func (m *sipMessage) addHeader(name, value string) {
+	m.headers = append(m.headers, sipHeader{name: name, value: value})
+}
+
+// setHeader replaces all values of the named header with a single value
+func (m *sipMessage) setHeader(name, value string) {
+	canonical := canonicalHeaderName(name)
+	headers := m.headers[:0]
+	replaced := false
+	for _, h := range m.headers {
+		if canonicalHeaderName(h.name) != canonical {
+			headers = append(headers, h)
+		} else if !replaced {
+			headers = append(headers, sipHeader{name: name, value: value})
+			replaced = true
+		}
+	}
+	m.headers = headers
+	if !replaced {
+		m.addHeader(name, value)
+	}
+}
+
+// cseq returns the sequence number and method of the CSeq header
+func (m *sipMessage) cseq() (int, string) {
+	number, method, _ := strings.Cut(m.header("CSeq"), " ")
+	n, _ := strconv.Atoi(number)
+	return n, strings.TrimSpace(method)
+}
+
+// branch returns the branch parameter of the top Via header
+func (m *sipMessage) branch() string {
+	return headerParam(m.header("Via"), "branch")
+}
+
+// bytes serializes the message, computing Content-Length from the body
+func (m *sipMessage) bytes() []byte {
+	var b strings.Builder
+	if m.isRequest() {
+		fmt.Fprintf(&b, "%s %s SIP/2.0\r\n", m.method, m.requestURI)
+	} else {
+		fmt.Fprintf(&b, "SIP/2.0 %d %s\r\n", m.statusCode, m.reason)
+	}
+	for _, h := range m.headers {
+		if canonicalHeaderName(h.name) == "content-length" {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\r\n", h.name, h.value)
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(m.body))
+	b.Write(m.body)
+	return []byte(b.String())
+}
+
+// splitHeaderList splits a comma-separated header value, ignoring commas inside quotes and <>
+func splitHeaderList(value string) []string {
+	var parts []string
+	var inQuotes, inAngle bool
+	start := 0
+	for i, c := range value {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '<' && !inQuotes:
+			inAngle = true
+		case c == '>' && !inQuotes:
+			inAngle = false
+		case c == ',' && !inQuotes && !inAngle:
+			parts = append(parts, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+// headerParam returns a ;name=value parameter of a header value, outside any <> URI
+func headerParam(value, name string) string {
+	if end := strings.LastIndex(value, ">"); end != -1 {
+		value = value[end+1:]
+	}
+	for _, param := range strings.Split(value, ";")[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, name) {
+			return strings.Trim(val, `"`)
+		}
+	}
+	return ""
+}
+
+// headerURI extracts the URI from a name-addr ("Name" <sip:...>) or addr-spec header value
+func headerURI(value string) string {
+	if start := strings.Index(value, "<"); start != -1 {
+		if end := strings.Index(value[start:], ">"); end != -1 {
+			return value[start+1 : start+end]
+		}
+	}
+	uri, _, _ := strings.Cut(value, ";")
+	return strings.TrimSpace(uri)
+}
+
+// withoutTag strips the tag parameter from a From/To header value
+func withoutTag(value string) string {
+	if tag := headerParam(value, "tag"); tag != "" {
+		return strings.Replace(value, ";tag="+tag, "", 1)
+	}
+	return value
+}
+
+// uriHostPort returns the host:port a SIP URI points at, defaulting to port 5060
+func uriHostPort(uri string) (string, error) {
+	rest, ok := strings.CutPrefix(uri, "sip:")
+	if !ok {
+		return "", fmt.Errorf("unsupported URI %q", uri)
+	}
+	if at := strings.LastIndex(rest, "@"); at != -1 {
+		rest = rest[at+1:]
+	}
+	if end := strings.IndexAny(rest, ";?"); end != -1 {
+		rest = rest[:end]
+	}
+	if _, _, err := net.SplitHostPort(rest); err != nil {
+		rest = net.JoinHostPort(strings.Trim(rest, "[]"), "5060")
+	}
+	return rest, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newBranch() string {
+	// The z9hG4bK magic cookie marks RFC 3261 compliant branches
+	return "z9hG4bK" + randomHex(8)
+}
@@ -0,0 +1,197 @@
+package sip
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParseSIPMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		message    string
+		method     string
+		requestURI string
+		statusCode int
+		reason     string
+		headers    map[string]string
+		body       string
+		err        string
+	}{
+		{
+			name:       "request",
+			message:    "INVITE sip:bob@example.com SIP/2.0\r\nVia: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1\r\nCall-ID: abc\r\nContent-Length: 4\r\n\r\nv=0\n",
+			method:     "INVITE",
+			requestURI: "sip:bob@example.com",
+			headers:    map[string]string{"Via": "SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1", "Call-ID": "abc"},
+			body:       "v=0\n",
+		},
+		{
+			name:       "response with multi-word reason",
+			message:    "SIP/2.0 407 Proxy Authentication Required\r\nCSeq: 1 INVITE\r\n\r\n",
+			statusCode: 407,
+			reason:     "Proxy Authentication Required",
+			headers:    map[string]string{"CSeq": "1 INVITE"},
+		},
+		{
+			name:       "compact headers",
+			message:    "BYE sip:alice@192.0.2.1 SIP/2.0\r\ni: abc\r\nf: <sip:bob@example.com>;tag=1\r\nl: 0\r\n\r\n",
+			method:     "BYE",
+			requestURI: "sip:alice@192.0.2.1",
+			headers:    map[string]string{"Call-ID": "abc", "From": "<sip:bob@example.com>;tag=1"},
+		},
+		{
+			name:       "folded header",
+			message:    "SIP/2.0 200 OK\r\nSubject: one\r\n two\r\n\r\n",
+			statusCode: 200,
+			reason:     "OK",
+			headers:    map[string]string{"Subject": "one two"},
+		},
+		{
+			name:       "Content-Length trims trailing data",
+			message:    "SIP/2.0 200 OK\r\nContent-Length: 2\r\n\r\nokjunk",
+			statusCode: 200,
+			reason:     "OK",
+			body:       "ok",
+		},
+		{name: "no end of headers", message: "SIP/2.0 200 OK\r\nCSeq: 1 INVITE\r\n", err: "no end of headers"},
+		{name: "short start line", message: "SIP/2.0 200\r\n\r\n", err: "malformed start line"},
+		{name: "status code out of range", message: "SIP/2.0 99 Odd\r\n\r\n", err: "invalid status code"},
+		{name: "status code not a number", message: "SIP/2.0 OK fine\r\n\r\n", err: "invalid status code"},
+		{name: "wrong version", message: "INVITE sip:bob@example.com SIP/3.0\r\n\r\n", err: "unsupported version"},
+		{name: "header without colon", message: "SIP/2.0 200 OK\r\nbroken\r\n\r\n", err: "malformed header"},
+		{name: "Content-Length beyond body", message: "SIP/2.0 200 OK\r\nContent-Length: 10\r\n\r\nshort", err: "invalid Content-Length"},
+		{name: "negative Content-Length", message: "SIP/2.0 200 OK\r\nContent-Length: -1\r\n\r\n", err: "invalid Content-Length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := parseSIPMessage([]byte(tt.message))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if msg.method != tt.method || msg.requestURI != tt.requestURI {
+				t.Errorf("request line = %q %q, want %q %q", msg.method, msg.requestURI, tt.method, tt.requestURI)
+			}
+			if msg.statusCode != tt.statusCode || msg.reason != tt.reason {
+				t.Errorf("status line = %d %q, want %d %q", msg.statusCode, msg.reason, tt.statusCode, tt.reason)
+			}
+			for name, want := range tt.headers {
+				if got := msg.header(name); got != want {
+					t.Errorf("header %s = %q, want %q", name, got, want)
+				}
+			}
+			if string(msg.body) != tt.body {
+				t.Errorf("body = %q, want %q", msg.body, tt.body)
+			}
+		})
+	}
+}
+
+func TestHeaderValues(t *testing.T) {
+	msg, err := parseSIPMessage([]byte("SIP/2.0 200 OK\r\n" +
+		"Contact: <sip:alice@192.0.2.1:5060>;expires=60, \"Bob, Jr\" <sip:bob@192.0.2.2;a=1,2>;expires=120\r\n" +
+		"m: <sip:carol@192.0.2.3>\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"<sip:alice@192.0.2.1:5060>;expires=60",
+		"\"Bob, Jr\" <sip:bob@192.0.2.2;a=1,2>;expires=120",
+		"<sip:carol@192.0.2.3>",
+	}
+	got := msg.headerValues("Contact")
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("Contact values = %q, want %q", got, want)
+	}
+	if expires := headerParam(got[1], "expires"); expires != "120" {
+		t.Errorf("expires = %q, want 120", expires)
+	}
+	if uri := headerURI(got[1]); uri != "sip:bob@192.0.2.2;a=1,2" {
+		t.Errorf("URI = %q", uri)
+	}
+}
+
+func TestOwnContact(t *testing.T) {
+	ua := &UserAgent{config: Config{User: "alice"}, contactHost: "192.0.2.1:5070"}
+
+	tests := []struct {
+		name     string
+		contacts string
+		want     string
+	}{
+		{"only ours", "<sip:alice@192.0.2.1:5070>;expires=60", "<sip:alice@192.0.2.1:5070>;expires=60"},
+		{"ours after another binding", "<sip:alice@198.51.100.7:5060>;expires=3600, <sip:alice@192.0.2.1:5070;transport=udp>;expires=60", "<sip:alice@192.0.2.1:5070;transport=udp>;expires=60"},
+		{"registrar changed the case", "<SIP:Alice@192.0.2.1:5070>;expires=60", "<SIP:Alice@192.0.2.1:5070>;expires=60"},
+		{"only other bindings", "<sip:alice@198.51.100.7:5060>;expires=3600", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := parseSIPMessage([]byte("SIP/2.0 200 OK\r\nContact: " + tt.contacts + "\r\n\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ua.ownContact(resp); got != tt.want {
+				t.Errorf("ownContact = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	md5Hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	// RFC 2617 section 3.5 credentials, without the random cnonce of qop=auth
+	header, err := digestAuthorization(`Digest realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+		"GET", "/dir/index.html", "Mufasa", "Circle Of Life")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Digest username="Mufasa", realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", uri="/dir/index.html", algorithm=MD5, response="670fd8c2df070c60b045671b8b24ff02", opaque="5ccc069c403ebaf9f0171e9517f40e41"`
+	if header != want {
+		t.Errorf("header =\n%s\nwant\n%s", header, want)
+	}
+
+	// With qop=auth the response covers a random cnonce, so check it against the one sent
+	header, err = digestAuthorization(`Digest realm="asterisk",nonce="abc123",qop="auth,auth-int",algorithm=MD5`,
+		"REGISTER", "sip:example.com", "test", "test123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := make(map[string]string)
+	_, list, _ := strings.Cut(header, " ")
+	for _, param := range splitHeaderList(list) {
+		key, value, _ := strings.Cut(param, "=")
+		params[key] = strings.Trim(value, `"`)
+	}
+	if params["qop"] != "auth" || params["nc"] != "00000001" || params["cnonce"] == "" {
+		t.Fatalf("qop parameters missing from %s", header)
+	}
+	ha1 := md5Hex("test:asterisk:test123")
+	ha2 := md5Hex("REGISTER:sip:example.com")
+	if want := md5Hex(ha1 + ":abc123:00000001:" + params["cnonce"] + ":auth:" + ha2); params["response"] != want {
+		t.Errorf("response = %s, want %s", params["response"], want)
+	}
+
+	for _, challenge := range []string{
+		`Basic realm="example.com"`,
+		`Digest realm="example.com", nonce="abc", algorithm=SHA-256`,
+	} {
+		if _, err := digestAuthorization(challenge, "REGISTER", "sip:example.com", "test", "test123"); err == nil {
+			t.Errorf("challenge %q accepted", challenge)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package sip
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PayloadTypePCMU is the only codec offered and accepted
+	PayloadTypePCMU = 0
+
+	pcmuClockRate = 8000
+)
+
+// openMediaSocket opens the local RTP socket advertised in our SDP
+func openMediaSocket(port int) (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RTP socket: %v", err)
+	}
+	return conn, nil
+}
+
+// buildSDP returns an SDP offer/answer for a single PCMU audio stream
+func buildSDP(name, ip string, port, ptime int) []byte {
+	sessionID := strconv.FormatInt(time.Now().Unix(), 10)
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=%s %s %s IN IP4 %s", name, sessionID, sessionID, ip),
+		"s=" + name,
+		"c=IN IP4 " + ip,
+		"t=0 0",
+		fmt.Sprintf("m=audio %d RTP/AVP %d", port, PayloadTypePCMU),
+		fmt.Sprintf("a=rtpmap:%d PCMU/%d", PayloadTypePCMU, pcmuClockRate),
+		fmt.Sprintf("a=ptime:%d", ptime),
+		"a=sendrecv",
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// parseSDP returns the remote RTP address of the first audio stream, which must offer PCMU
+func parseSDP(body []byte) (*net.UDPAddr, error) {
+	var connection string
+	var port int
+	var formats []string
+	inAudio := false
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			// Only the first audio stream is used
+			fields := strings.Fields(line[2:])
+			inAudio = port == 0 && len(fields) >= 4 && fields[0] == "audio"
+			if inAudio {
+				port, _ = strconv.Atoi(fields[1])
+				formats = fields[3:]
+			}
+		case strings.HasPrefix(line, "c="):
+			// Session-level c= applies unless the audio stream has its own
+			if connection == "" || inAudio {
+				fields := strings.Fields(line[2:])
+				if len(fields) >= 3 {
+					connection = fields[2]
+				}
+			}
+		}
+	}
+
+	if port == 0 {
+		return nil, fmt.Errorf("no audio stream")
+	}
+	if connection == "" {
+		return nil, fmt.Errorf("no connection address")
+	}
+
+	hasPCMU := false
+	for _, format := range formats {
+		if format == strconv.Itoa(PayloadTypePCMU) {
+			hasPCMU = true
+		}
+	}
+	if !hasPCMU {
+		return nil, fmt.Errorf("PCMU not offered (formats %v)", formats)
+	}
+
+	ip := net.ParseIP(connection)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid connection address %q", connection)
+	}
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
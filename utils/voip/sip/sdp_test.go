@@ -0,0 +1,93 @@
+package sip
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildSDP(t *testing.T) {
+	sdp := string(buildSDP("softphone", "192.0.2.1", 40000, 20))
+
+	for _, line := range []string{
+		"v=0\r\n",
+		"c=IN IP4 192.0.2.1\r\n",
+		"m=audio 40000 RTP/AVP 0\r\n",
+		"a=rtpmap:0 PCMU/8000\r\n",
+		"a=ptime:20\r\n",
+		"a=sendrecv\r\n",
+	} {
+		if !strings.Contains(sdp, line) {
+			t.Errorf("SDP is missing %q:\n%s", line, sdp)
+		}
+	}
+
+	addr, err := parseSDP([]byte(sdp))
+	if err != nil {
+		t.Fatalf("parsing our own SDP: %v", err)
+	}
+	if addr.String() != "192.0.2.1:40000" {
+		t.Errorf("address = %s, want 192.0.2.1:40000", addr)
+	}
+}
+
+func TestParseSDP(t *testing.T) {
+	tests := []struct {
+		name string
+		sdp  string
+		addr string
+		err  string
+	}{
+		{
+			name: "session-level connection",
+			sdp:  "v=0\r\nc=IN IP4 203.0.113.5\r\nt=0 0\r\nm=audio 30000 RTP/AVP 8 0 101\r\n",
+			addr: "203.0.113.5:30000",
+		},
+		{
+			name: "media-level connection overrides session",
+			sdp:  "v=0\r\nc=IN IP4 203.0.113.5\r\nm=audio 30000 RTP/AVP 0\r\nc=IN IP4 198.51.100.9\r\n",
+			addr: "198.51.100.9:30000",
+		},
+		{
+			name: "video stream's connection is ignored",
+			sdp:  "v=0\r\nc=IN IP4 203.0.113.5\r\nm=audio 30000 RTP/AVP 0\r\nm=video 30002 RTP/AVP 96\r\nc=IN IP4 198.51.100.9\r\n",
+			addr: "203.0.113.5:30000",
+		},
+		{
+			name: "only the first audio stream is used",
+			sdp:  "v=0\r\nc=IN IP4 203.0.113.5\r\nm=audio 30000 RTP/AVP 0\r\nm=audio 40000 RTP/AVP 0\r\n",
+			addr: "203.0.113.5:30000",
+		},
+		{
+			name: "LF line endings",
+			sdp:  "v=0\nc=IN IP4 203.0.113.5\nm=audio 30000 RTP/AVP 0\n",
+			addr: "203.0.113.5:30000",
+		},
+		{
+			name: "IPv6",
+			sdp:  "v=0\r\nc=IN IP6 2001:db8::1\r\nm=audio 30000 RTP/AVP 0\r\n",
+			addr: "[2001:db8::1]:30000",
+		},
+		{name: "no audio", sdp: "v=0\r\nc=IN IP4 203.0.113.5\r\nm=video 30000 RTP/AVP 96\r\n", err: "no audio stream"},
+		{name: "no connection", sdp: "v=0\r\nm=audio 30000 RTP/AVP 0\r\n", err: "no connection address"},
+		{name: "no PCMU", sdp: "v=0\r\nc=IN IP4 203.0.113.5\r\nm=audio 30000 RTP/AVP 8 101\r\n", err: "PCMU not offered"},
+		{name: "hostname connection", sdp: "v=0\r\nc=IN IP4 media.example.com\r\nm=audio 30000 RTP/AVP 0\r\n", err: "invalid connection address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := parseSDP([]byte(tt.sdp))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if addr.String() != tt.addr {
+				t.Errorf("address = %s, want %s", addr, tt.addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,920 @@
+package sip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sipT1 = 500 * time.Millisecond
+	sipT2 = 4 * time.Second
+
+	// Timer B/F: how long a transaction waits for any response
+	transactionTimeout = 64 * sipT1
+)
+
+// Config configures a user agent
+type Config struct {
+	Name      string // sent as User-Agent and the SDP session name
+	Server    string // outbound proxy (host:port); all requests are sent through it if set
+	User      string
+	AuthUser  string
+	Password  string
+	Domain    string
+	LocalPort int    // SIP port, random if 0
+	PublicIP  string // advertised in Contact/Via and SDP
+	RTPPort   int    // media port, random if 0
+	Ptime     int    // packetization time advertised in SDP, in milliseconds
+	Expires   int    // registration lifetime requested by RefreshRegistration
+	Answer    bool   // accept one incoming call for Answer; otherwise incoming calls are refused
+	Timeout   int    // seconds to wait for an answer or an incoming call
+	Trace     bool   // log every SIP message sent and received
+
+	// Logf reports call progress; nil discards it
+	Logf func(format string, args ...interface{})
+}
+
+// Dialog is the state of an established (or establishing) call
+type Dialog struct {
+	callID       string
+	localTag     string
+	remoteTag    string
+	localURI     string // From/To value of our side, without tag
+	remoteURI    string // From/To value of the other side, without tag
+	remoteTarget string // Contact URI of the other side
+	routeSet     []string
+	localSeq     int
+	remoteMedia  *net.UDPAddr // guarded by mu once media is running
+	mediaConn    *net.UDPConn
+	localSDP     []byte // our offer/answer, repeated unchanged when a re-INVITE refreshes the session
+
+	invite *sipMessage // the INVITE we are answering, for retransmitted responses
+
+	mu            sync.Mutex
+	lastReply     *sipMessage // the last response sent to invite
+	lastACK       *sipMessage // the ACK sent for a 2xx, resent if the 2xx is retransmitted
+	reinvite      *sipMessage // the last re-INVITE received in the dialog
+	reinviteReply *sipMessage
+
+	acked   chan struct{}
+	ended   chan struct{}
+	endOnce sync.Once
+	reason  string
+}
+
+func newDialog() *Dialog {
+	return &Dialog{acked: make(chan struct{}), ended: make(chan struct{})}
+}
+
+// setLastReply records the latest response to the INVITE being answered
+func (d *Dialog) setLastReply(resp *sipMessage) {
+	d.mu.Lock()
+	d.lastReply = resp
+	d.mu.Unlock()
+}
+
+func (d *Dialog) getLastReply() *sipMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastReply
+}
+
+func (d *Dialog) getLastACK() *sipMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastACK
+}
+
+// RemoteMedia returns where the far end wants RTP, which a re-INVITE may change mid-call
+func (d *Dialog) RemoteMedia() *net.UDPAddr {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.remoteMedia
+}
+
+// end marks the dialog as terminated by the other side
+func (d *Dialog) end(reason string) {
+	d.endOnce.Do(func() {
+		d.reason = reason
+		close(d.ended)
+	})
+}
+
+// CallID identifies the call in SIP traces and logs
+func (d *Dialog) CallID() string {
+	return d.callID
+}
+
+// MediaConn returns the local RTP socket advertised in our SDP; the caller closes it when the call ends
+func (d *Dialog) MediaConn() *net.UDPConn {
+	return d.mediaConn
+}
+
+// Ended is closed when the other side ends the call
+func (d *Dialog) Ended() <-chan struct{} {
+	return d.ended
+}
+
+// Reason says why the other side ended the call, once Ended is closed
+func (d *Dialog) Reason() string {
+	return d.reason
+}
+
+// UserAgent is a minimal SIP user agent over UDP, handling one call at a time
+type UserAgent struct {
+	config      Config
+	conn        *net.UDPConn
+	server      *net.UDPAddr // outbound proxy, nil to send directly to the request URI
+	contactHost string
+
+	mu           sync.Mutex
+	transactions map[string]chan *sipMessage
+	current      *Dialog
+	invites      chan *Dialog
+
+	regMu      sync.Mutex
+	regCallID  string
+	regSeq     int
+	regExpires int
+}
+
+// NewUserAgent opens the SIP socket and starts handling incoming messages
+func NewUserAgent(config Config) (*UserAgent, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: config.LocalPort})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SIP socket: %v", err)
+	}
+
+	ua := &UserAgent{
+		config:       config,
+		conn:         conn,
+		contactHost:  net.JoinHostPort(config.PublicIP, strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)),
+		transactions: make(map[string]chan *sipMessage),
+		invites:      make(chan *Dialog, 1),
+		regCallID:    randomHex(12),
+	}
+
+	if config.Server != "" {
+		server := config.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "5060")
+		}
+		ua.server, err = net.ResolveUDPAddr("udp4", server)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to resolve server: %v", err)
+		}
+	}
+
+	go ua.readLoop()
+	return ua, nil
+}
+
+func (ua *UserAgent) Close() {
+	ua.conn.Close()
+}
+
+// LocalAddr returns the address of the SIP socket
+func (ua *UserAgent) LocalAddr() net.Addr {
+	return ua.conn.LocalAddr()
+}
+
+// ContactHost returns the host:port advertised in Contact and Via
+func (ua *UserAgent) ContactHost() string {
+	return ua.contactHost
+}
+
+func (ua *UserAgent) logf(format string, args ...interface{}) {
+	if ua.config.Logf != nil {
+		ua.config.Logf(format, args...)
+	}
+}
+
+func (ua *UserAgent) aor() string {
+	return fmt.Sprintf("<sip:%s@%s>", ua.config.User, ua.config.Domain)
+}
+
+func (ua *UserAgent) contact() string {
+	return fmt.Sprintf("<sip:%s@%s>", ua.config.User, ua.contactHost)
+}
+
+// NormalizeTarget turns a bare user or number into a SIP URI in domain
+func NormalizeTarget(target, domain string) (string, error) {
+	if strings.HasPrefix(target, "sip:") {
+		return target, nil
+	}
+	if strings.Contains(target, "@") {
+		return "sip:" + target, nil
+	}
+	if domain == "" {
+		return "", fmt.Errorf("%q has no host; use a full SIP URI or set --server or --domain", target)
+	}
+	return fmt.Sprintf("sip:%s@%s", target, domain), nil
+}
+
+// destination returns where a request for uri is sent
+func (ua *UserAgent) destination(uri string) (*net.UDPAddr, error) {
+	if ua.server != nil {
+		return ua.server, nil
+	}
+	hostPort, err := uriHostPort(uri)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp4", hostPort)
+}
+
+func (ua *UserAgent) write(msg *sipMessage, addr *net.UDPAddr) error {
+	if ua.config.Trace {
+		ua.logf("SIP: Sending to %s:\n%s", addr, msg.bytes())
+	}
+	_, err := ua.conn.WriteToUDP(msg.bytes(), addr)
+	return err
+}
+
+func (ua *UserAgent) readLoop() {
+	buffer := make([]byte, 65536)
+	for {
+		n, from, err := ua.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("SIP: Error reading: %v", err)
+			continue
+		}
+
+		// Keep-alives (CRLF) and other noise are not SIP messages
+		data := buffer[:n]
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		msg, err := parseSIPMessage(data)
+		if err != nil {
+			if ua.config.Trace {
+				ua.logf("SIP: Ignoring malformed message from %s: %v", from, err)
+			}
+			continue
+		}
+		if ua.config.Trace {
+			ua.logf("SIP: Received from %s:\n%s", from, data)
+		}
+
+		if msg.isRequest() {
+			ua.handleRequest(msg, from)
+		} else {
+			ua.handleResponse(msg, from)
+		}
+	}
+}
+
+func (ua *UserAgent) handleResponse(msg *sipMessage, from *net.UDPAddr) {
+	ua.mu.Lock()
+	responses, ok := ua.transactions[msg.branch()]
+	d := ua.current
+	ua.mu.Unlock()
+
+	if ok {
+		select {
+		case responses <- msg:
+		default:
+		}
+		return
+	}
+
+	// A retransmitted 2xx to our INVITE means our ACK was lost
+	if _, method := msg.cseq(); method == "INVITE" && msg.statusCode < 300 && d != nil && msg.header("Call-ID") == d.callID {
+		if ack := d.getLastACK(); ack != nil {
+			ua.write(ack, from)
+		}
+	}
+}
+
+// newResponse builds a response to req, adding our tag to To when tag is set
+func (ua *UserAgent) newResponse(req *sipMessage, code int, reason, tag string) *sipMessage {
+	resp := &sipMessage{statusCode: code, reason: reason}
+	for _, h := range req.headers {
+		switch canonicalHeaderName(h.name) {
+		case "via", "from", "call-id", "cseq", "record-route":
+			resp.addHeader(h.name, h.value)
+		case "to":
+			value := h.value
+			if tag != "" && headerParam(value, "tag") == "" {
+				value += ";tag=" + tag
+			}
+			resp.addHeader(h.name, value)
+		}
+	}
+	resp.addHeader("User-Agent", ua.config.Name)
+	return resp
+}
+
+func (ua *UserAgent) reply(req *sipMessage, from *net.UDPAddr, code int, reason string) {
+	ua.write(ua.newResponse(req, code, reason, ""), from)
+}
+
+func (ua *UserAgent) handleRequest(req *sipMessage, from *net.UDPAddr) {
+	ua.mu.Lock()
+	d := ua.current
+	ua.mu.Unlock()
+
+	inDialog := d != nil && req.header("Call-ID") == d.callID
+
+	switch req.method {
+	case "INVITE":
+		if inDialog && d.invite != nil && req.branch() == d.invite.branch() {
+			// Retransmission: repeat whatever we last answered
+			if reply := d.getLastReply(); reply != nil {
+				ua.write(reply, from)
+			}
+			return
+		}
+		if inDialog {
+			ua.handleReinvite(d, req, from)
+			return
+		}
+		if !ua.config.Answer || d != nil {
+			ua.reply(req, from, 486, "Busy Here")
+			return
+		}
+		ua.acceptInvite(req, from)
+
+	case "ACK":
+		if inDialog {
+			select {
+			case <-d.acked:
+			default:
+				close(d.acked)
+			}
+		}
+
+	case "BYE":
+		if !inDialog {
+			ua.reply(req, from, 481, "Call/Transaction Does Not Exist")
+			return
+		}
+		ua.reply(req, from, 200, "OK")
+		d.end("remote hung up")
+
+	case "CANCEL":
+		if !inDialog || d.invite == nil {
+			ua.reply(req, from, 481, "Call/Transaction Does Not Exist")
+			return
+		}
+		ua.reply(req, from, 200, "OK")
+		select {
+		case <-d.acked:
+			// Already answered, CANCEL has no effect
+		default:
+			terminated := ua.newResponse(d.invite, 487, "Request Terminated", d.localTag)
+			d.setLastReply(terminated)
+			ua.write(terminated, from)
+			d.end("caller cancelled")
+		}
+
+	case "OPTIONS", "NOTIFY":
+		ua.reply(req, from, 200, "OK")
+
+	default:
+		resp := ua.newResponse(req, 405, "Method Not Allowed", "")
+		resp.addHeader("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS, NOTIFY")
+		ua.write(resp, from)
+	}
+}
+
+// handleReinvite answers a re-INVITE in an established call, e.g. a session timer refresh.
+// Offers that still include PCMU are accepted with our unchanged SDP, following any new
+// media address; anything else (such as dropping PCMU) is refused and the call continues as before.
+func (ua *UserAgent) handleReinvite(d *Dialog, req *sipMessage, from *net.UDPAddr) {
+	d.mu.Lock()
+	previous, reply, localSDP := d.reinvite, d.reinviteReply, d.localSDP
+	d.mu.Unlock()
+	if previous != nil && req.branch() == previous.branch() {
+		// Retransmission: our response was lost
+		if reply != nil {
+			ua.write(reply, from)
+		}
+		return
+	}
+
+	if localSDP == nil {
+		// Still setting up the call
+		ua.reply(req, from, 491, "Request Pending")
+		return
+	}
+
+	// An INVITE without SDP asks us to make the offer; our current SDP is sent and the answer in the ACK is ignored
+	var remoteMedia *net.UDPAddr
+	if len(bytes.TrimSpace(req.body)) > 0 {
+		var err error
+		remoteMedia, err = parseSDP(req.body)
+		if err != nil {
+			if ua.config.Trace {
+				ua.logf("Refusing re-INVITE: %v", err)
+			}
+			ua.reply(req, from, 488, "Not Acceptable Here")
+			return
+		}
+	}
+
+	ok := ua.newResponse(req, 200, "OK", "")
+	ok.addHeader("Contact", ua.contact())
+	ok.addHeader("Content-Type", "application/sdp")
+	ok.body = localSDP
+
+	d.mu.Lock()
+	d.reinvite, d.reinviteReply = req, ok
+	if remoteMedia != nil && remoteMedia.String() != d.remoteMedia.String() {
+		ua.logf("Re-INVITE moves media to %s", remoteMedia)
+		d.remoteMedia = remoteMedia
+	}
+	d.mu.Unlock()
+
+	ua.write(ok, from)
+}
+
+// acceptInvite starts a server-side dialog for an incoming INVITE and hands it to Answer
+func (ua *UserAgent) acceptInvite(req *sipMessage, from *net.UDPAddr) {
+	d := newDialog()
+	d.invite = req
+	d.callID = req.header("Call-ID")
+	d.localTag = randomHex(4)
+	d.remoteTag = headerParam(req.header("From"), "tag")
+	d.localURI = withoutTag(req.header("To"))
+	d.remoteURI = withoutTag(req.header("From"))
+	d.remoteTarget = headerURI(req.header("Contact"))
+	d.routeSet = req.headerValues("Record-Route")
+
+	trying := ua.newResponse(req, 100, "Trying", "")
+	d.setLastReply(trying)
+	ua.write(trying, from)
+
+	ua.mu.Lock()
+	ua.current = d
+	ua.mu.Unlock()
+
+	ua.logf("Incoming call from %s (Call-ID %s)", d.remoteURI, d.callID)
+	ua.invites <- d
+}
+
+// Answer waits for an incoming INVITE, answers it and waits for the ACK
+func (ua *UserAgent) Answer(stop <-chan struct{}) (*Dialog, error) {
+	ua.logf("Waiting up to %ds for an incoming call...", ua.config.Timeout)
+
+	var d *Dialog
+	select {
+	case d = <-ua.invites:
+	case <-stop:
+		return nil, fmt.Errorf("interrupted while waiting for a call")
+	case <-time.After(time.Duration(ua.config.Timeout) * time.Second):
+		return nil, fmt.Errorf("no incoming call within %d seconds", ua.config.Timeout)
+	}
+
+	// Responses go back to where the INVITE came from, like rport
+	from, err := ua.replyAddress(d.invite)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteMedia, err := parseSDP(d.invite.body)
+	if err != nil {
+		ua.answerWith(d, from, ua.newResponse(d.invite, 488, "Not Acceptable Here", d.localTag))
+		return nil, fmt.Errorf("unusable SDP offer: %v", err)
+	}
+	mediaConn, err := openMediaSocket(ua.config.RTPPort)
+	if err != nil {
+		ua.answerWith(d, from, ua.newResponse(d.invite, 500, "Server Internal Error", d.localTag))
+		return nil, err
+	}
+	d.mediaConn = mediaConn
+
+	ua.answerWith(d, from, ua.newResponse(d.invite, 180, "Ringing", d.localTag))
+
+	ok := ua.newResponse(d.invite, 200, "OK", d.localTag)
+	ok.addHeader("Contact", ua.contact())
+	ok.addHeader("Content-Type", "application/sdp")
+	ok.body = buildSDP(ua.config.Name, ua.config.PublicIP, mediaConn.LocalAddr().(*net.UDPAddr).Port, ua.config.Ptime)
+	d.mu.Lock()
+	d.remoteMedia = remoteMedia
+	d.localSDP = ok.body
+	d.mu.Unlock()
+	d.setLastReply(ok)
+
+	// UDP: retransmit the 2xx until the ACK arrives (RFC 3261 section 13.3.1.4)
+	interval := sipT1
+	deadline := time.After(transactionTimeout)
+	for {
+		ua.write(ok, from)
+		select {
+		case <-d.acked:
+			return d, nil
+		case <-d.ended:
+			mediaConn.Close()
+			return nil, fmt.Errorf("call ended before it was established: %s", d.reason)
+		case <-deadline:
+			ua.Bye(d)
+			mediaConn.Close()
+			return nil, fmt.Errorf("no ACK received for 200 OK")
+		case <-time.After(interval):
+			interval = min(interval*2, sipT2)
+		}
+	}
+}
+
+// answerWith sends a response to the INVITE being answered and remembers it for retransmissions
+func (ua *UserAgent) answerWith(d *Dialog, to *net.UDPAddr, resp *sipMessage) {
+	d.setLastReply(resp)
+	ua.write(resp, to)
+}
+
+// replyAddress returns where responses to req are sent, honoring Via received/rport
+func (ua *UserAgent) replyAddress(req *sipMessage) (*net.UDPAddr, error) {
+	via := req.header("Via")
+	_, sentBy, _ := strings.Cut(strings.SplitN(via, ";", 2)[0], " ")
+	host, port, err := net.SplitHostPort(strings.TrimSpace(sentBy))
+	if err != nil {
+		host, port = strings.TrimSpace(sentBy), "5060"
+	}
+	if received := headerParam(via, "received"); received != "" {
+		host = received
+	}
+	if rport := headerParam(via, "rport"); rport != "" {
+		port = rport
+	}
+	return net.ResolveUDPAddr("udp4", net.JoinHostPort(host, port))
+}
+
+// newRequest builds an out-of-dialog request, or an in-dialog one when d is set
+func (ua *UserAgent) newRequest(method, requestURI string, d *Dialog) *sipMessage {
+	req := &sipMessage{method: method, requestURI: requestURI}
+	req.addHeader("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=%s;rport", ua.contactHost, newBranch()))
+	req.addHeader("Max-Forwards", "70")
+	if d != nil {
+		for _, route := range d.routeSet {
+			req.addHeader("Route", route)
+		}
+		req.addHeader("From", fmt.Sprintf("%s;tag=%s", d.localURI, d.localTag))
+		to := d.remoteURI
+		if d.remoteTag != "" {
+			to += ";tag=" + d.remoteTag
+		}
+		req.addHeader("To", to)
+		req.addHeader("Call-ID", d.callID)
+		req.addHeader("CSeq", fmt.Sprintf("%d %s", d.localSeq, method))
+	}
+	req.addHeader("Contact", ua.contact())
+	req.addHeader("User-Agent", ua.config.Name)
+	return req
+}
+
+// transact sends a request and returns its final response, retransmitting over UDP.
+// Provisional responses are passed to onProvisional; cancel, if not nil, sends a CANCEL.
+func (ua *UserAgent) transact(req *sipMessage, dest *net.UDPAddr, onProvisional func(*sipMessage), cancel <-chan struct{}) (*sipMessage, error) {
+	branch := req.branch()
+	responses := make(chan *sipMessage, 16)
+
+	ua.mu.Lock()
+	ua.transactions[branch] = responses
+	ua.mu.Unlock()
+	defer func() {
+		ua.mu.Lock()
+		delete(ua.transactions, branch)
+		ua.mu.Unlock()
+	}()
+
+	if err := ua.write(req, dest); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %v", req.method, err)
+	}
+
+	interval := sipT1
+	retransmit := time.NewTimer(interval)
+	defer retransmit.Stop()
+	// INVITEs are bounded by --timeout whether or not the callee is ringing; other requests use Timer F
+	timeout := time.After(transactionTimeout)
+	if req.method == "INVITE" {
+		timeout = time.After(time.Duration(ua.config.Timeout) * time.Second)
+	}
+	provisional := false
+	cancelRequested, cancelled := false, false
+
+	for {
+		select {
+		case resp := <-responses:
+			if _, method := resp.cseq(); method != req.method {
+				// Responses to our CANCEL share the INVITE's branch
+				continue
+			}
+			if resp.statusCode < 200 {
+				if !provisional && req.method == "INVITE" {
+					// A provisional response stops INVITE retransmissions; wait for the callee instead
+					retransmit.Stop()
+				}
+				provisional = true
+				if onProvisional != nil {
+					onProvisional(resp)
+				}
+				if cancelRequested && !cancelled {
+					ua.sendCancel(req, dest)
+					cancelled = true
+				}
+				continue
+			}
+			if req.method == "INVITE" && resp.statusCode >= 300 {
+				// Non-2xx final responses are acknowledged within the transaction
+				ua.write(ua.ackFor(req, resp), dest)
+			}
+			return resp, nil
+
+		case <-retransmit.C:
+			ua.write(req, dest)
+			if req.method == "INVITE" {
+				interval *= 2
+			} else {
+				interval = min(interval*2, sipT2)
+			}
+			retransmit.Reset(interval)
+
+		case <-cancel:
+			// A CANCEL may only be sent once a provisional response has arrived
+			cancel = nil
+			cancelRequested = true
+			if provisional && !cancelled {
+				ua.sendCancel(req, dest)
+				cancelled = true
+			}
+
+		case <-timeout:
+			if req.method == "INVITE" && provisional && !cancelled {
+				ua.sendCancel(req, dest)
+				cancelled = true
+				timeout = time.After(transactionTimeout)
+				continue
+			}
+			if req.method == "INVITE" && !cancelled {
+				return nil, fmt.Errorf("no answer within %d seconds", ua.config.Timeout)
+			}
+			return nil, fmt.Errorf("no final response to %s", req.method)
+		}
+	}
+}
+
+// ackFor builds the ACK for a non-2xx final response to an INVITE, which reuses the INVITE's branch
+func (ua *UserAgent) ackFor(invite, resp *sipMessage) *sipMessage {
+	ack := &sipMessage{method: "ACK", requestURI: invite.requestURI}
+	ack.addHeader("Via", invite.header("Via"))
+	ack.addHeader("Max-Forwards", "70")
+	for _, route := range invite.headerValues("Route") {
+		ack.addHeader("Route", route)
+	}
+	ack.addHeader("From", invite.header("From"))
+	ack.addHeader("To", resp.header("To"))
+	ack.addHeader("Call-ID", invite.header("Call-ID"))
+	seq, _ := invite.cseq()
+	ack.addHeader("CSeq", fmt.Sprintf("%d ACK", seq))
+	return ack
+}
+
+func (ua *UserAgent) sendCancel(invite *sipMessage, dest *net.UDPAddr) {
+	cancel := &sipMessage{method: "CANCEL", requestURI: invite.requestURI}
+	cancel.addHeader("Via", invite.header("Via"))
+	cancel.addHeader("Max-Forwards", "70")
+	for _, route := range invite.headerValues("Route") {
+		cancel.addHeader("Route", route)
+	}
+	cancel.addHeader("From", invite.header("From"))
+	cancel.addHeader("To", invite.header("To"))
+	cancel.addHeader("Call-ID", invite.header("Call-ID"))
+	seq, _ := invite.cseq()
+	cancel.addHeader("CSeq", fmt.Sprintf("%d CANCEL", seq))
+	ua.write(cancel, dest)
+}
+
+// transactWithAuth runs a transaction, answering a single digest challenge if needed
+func (ua *UserAgent) transactWithAuth(req *sipMessage, dest *net.UDPAddr, onProvisional func(*sipMessage), cancel <-chan struct{}) (*sipMessage, error) {
+	resp, err := ua.transact(req, dest, onProvisional, cancel)
+	if err != nil || (resp.statusCode != 401 && resp.statusCode != 407) {
+		return resp, err
+	}
+
+	challengeHeader, authHeader := "WWW-Authenticate", "Authorization"
+	if resp.statusCode == 407 {
+		challengeHeader, authHeader = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+	if ua.config.Password == "" {
+		return resp, fmt.Errorf("server requires authentication, specify --password")
+	}
+
+	authorization, err := digestAuthorization(resp.header(challengeHeader), req.method, req.requestURI, ua.config.AuthUser, ua.config.Password)
+	if err != nil {
+		return resp, err
+	}
+
+	// The retry is a new transaction with the next CSeq
+	seq, method := req.cseq()
+	req.setHeader("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=%s;rport", ua.contactHost, newBranch()))
+	req.setHeader("CSeq", fmt.Sprintf("%d %s", seq+1, method))
+	req.setHeader(authHeader, authorization)
+
+	resp, err = ua.transact(req, dest, onProvisional, cancel)
+	if err == nil && (resp.statusCode == 401 || resp.statusCode == 407) {
+		return resp, fmt.Errorf("authentication rejected for %s", ua.config.AuthUser)
+	}
+	return resp, err
+}
+
+// Register sends a REGISTER for our address of record; expires 0 removes the binding
+func (ua *UserAgent) Register(expires int) error {
+	ua.regMu.Lock()
+	defer ua.regMu.Unlock()
+
+	ua.regSeq++
+	req := ua.newRequest("REGISTER", "sip:"+ua.config.Domain, nil)
+	req.addHeader("From", fmt.Sprintf("%s;tag=%s", ua.aor(), randomHex(4)))
+	req.addHeader("To", ua.aor())
+	req.addHeader("Call-ID", ua.regCallID)
+	req.addHeader("CSeq", fmt.Sprintf("%d REGISTER", ua.regSeq))
+	req.addHeader("Expires", strconv.Itoa(expires))
+
+	resp, err := ua.transactWithAuth(req, ua.server, nil, nil)
+	if err != nil {
+		return err
+	}
+	ua.regSeq, _ = req.cseq()
+
+	if resp.statusCode >= 300 {
+		return fmt.Errorf("server answered %d %s", resp.statusCode, resp.reason)
+	}
+
+	if expires == 0 {
+		ua.logf("Unregistered %s", ua.aor())
+		return nil
+	}
+
+	// The registrar may grant a shorter lifetime than requested. The 200 OK lists
+	// every binding of the AOR, so only our own Contact's expires applies
+	granted := expires
+	if value, err := strconv.Atoi(headerParam(ua.ownContact(resp), "expires")); err == nil {
+		granted = value
+	} else if value, err := strconv.Atoi(resp.header("Expires")); err == nil {
+		granted = value
+	}
+	ua.regExpires = granted
+
+	ua.logf("Registered %s as %s (expires in %ds)", ua.aor(), ua.contact(), granted)
+	return nil
+}
+
+// ownContact returns the Contact of a REGISTER response that matches ours, or "" if the registrar did not list it
+func (ua *UserAgent) ownContact(resp *sipMessage) string {
+	ours := contactURI(ua.contact())
+	for _, value := range resp.headerValues("Contact") {
+		if contactURI(value) == ours {
+			return value
+		}
+	}
+	return ""
+}
+
+// contactURI returns a Contact's URI without its parameters, for comparing bindings
+func contactURI(value string) string {
+	uri, _, _ := strings.Cut(headerURI(value), ";")
+	return strings.ToLower(uri)
+}
+
+// RefreshRegistration re-registers halfway through each registration lifetime until stop is closed
+func (ua *UserAgent) RefreshRegistration(stop <-chan struct{}) {
+	for {
+		ua.regMu.Lock()
+		interval := time.Duration(ua.regExpires) * time.Second / 2
+		ua.regMu.Unlock()
+		if interval < time.Second {
+			interval = time.Second
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+
+		if err := ua.Register(ua.config.Expires); err != nil {
+			log.Printf("Failed to refresh registration: %v", err)
+		}
+	}
+}
+
+// Invite places a call to target and returns the established dialog
+func (ua *UserAgent) Invite(target string, stop <-chan struct{}) (*Dialog, error) {
+	dest, err := ua.destination(target)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaConn, err := openMediaSocket(ua.config.RTPPort)
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDialog()
+	d.callID = randomHex(12)
+	d.localTag = randomHex(4)
+	d.localURI = ua.aor()
+	if ua.config.Domain == "" {
+		d.localURI = ua.contact()
+	}
+	d.remoteURI = "<" + target + ">"
+	d.localSeq = 1
+	d.mediaConn = mediaConn
+
+	ua.mu.Lock()
+	ua.current = d
+	ua.mu.Unlock()
+
+	req := ua.newRequest("INVITE", target, d)
+	req.addHeader("Content-Type", "application/sdp")
+	req.body = buildSDP(ua.config.Name, ua.config.PublicIP, mediaConn.LocalAddr().(*net.UDPAddr).Port, ua.config.Ptime)
+	d.mu.Lock()
+	d.localSDP = req.body
+	d.mu.Unlock()
+
+	ua.logf("Calling %s via %s", target, dest)
+
+	resp, err := ua.transactWithAuth(req, dest, func(resp *sipMessage) {
+		ua.logf("Call progress: %d %s", resp.statusCode, resp.reason)
+	}, stop)
+	if err != nil {
+		mediaConn.Close()
+		return nil, err
+	}
+	d.localSeq, _ = req.cseq()
+
+	if resp.statusCode >= 300 {
+		mediaConn.Close()
+		return nil, fmt.Errorf("call failed: %d %s", resp.statusCode, resp.reason)
+	}
+
+	d.remoteTag = headerParam(resp.header("To"), "tag")
+	d.remoteTarget = headerURI(resp.header("Contact"))
+	if d.remoteTarget == "" {
+		d.remoteTarget = target
+	}
+
+	// The UAC route set is the Record-Route of the 2xx in reverse order
+	records := resp.headerValues("Record-Route")
+	for i := len(records) - 1; i >= 0; i-- {
+		d.routeSet = append(d.routeSet, records[i])
+	}
+
+	// The 2xx ACK is its own transaction with a new branch, sent to the remote target
+	ack := ua.newRequest("ACK", d.remoteTarget, d)
+	d.mu.Lock()
+	d.lastACK = ack
+	d.mu.Unlock()
+	ackDest, err := ua.destination(d.remoteTarget)
+	if err != nil {
+		mediaConn.Close()
+		return nil, err
+	}
+	ua.write(ack, ackDest)
+
+	remoteMedia, err := parseSDP(resp.body)
+	if err != nil {
+		ua.Bye(d)
+		mediaConn.Close()
+		return nil, fmt.Errorf("unusable SDP answer: %v", err)
+	}
+	d.mu.Lock()
+	d.remoteMedia = remoteMedia
+	d.mu.Unlock()
+
+	return d, nil
+}
+
+// Bye hangs up an established call
+func (ua *UserAgent) Bye(d *Dialog) error {
+	d.localSeq++
+	req := ua.newRequest("BYE", d.remoteTarget, d)
+
+	dest, err := ua.destination(d.remoteTarget)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ua.transactWithAuth(req, dest, nil, nil)
+	d.localSeq, _ = req.cseq()
+	if err != nil {
+		return err
+	}
+	if resp.statusCode >= 300 {
+		return fmt.Errorf("BYE answered %d %s", resp.statusCode, resp.reason)
+	}
+	return nil
+}